@@ -2,15 +2,18 @@ package main
 
 import (
 	"bufio"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/chzyer/readline"
 	"github.com/pborman/getopt/v2"
 	tokenizer "github.com/samber/go-gpt-3-encoder"
 	gogpt "github.com/sashabaranov/go-openai"
@@ -32,16 +35,51 @@ type AIChat struct {
 	client       *gogpt.Client
 	encoder      *tokenizer.Encoder
 	options      chatOptions
+	// defaults are the options given on the command line or in the config,
+	// which a conversation without saved settings starts with.
+	defaults     chatOptions
 	conversation *Conversation
+	cache        *ResponseCache
+	config       Config
+	// inputTemplate wraps the next user message when a chat is started from a prompt.
+	inputTemplate func(string) string
+	// attachments are the files added with /file to be sent with the next message.
+	attachments []attachment
+	// commands are the slash commands of the chat loop.
+	commands *commandRegistry
+	// reader reads the user's input in the chat loop.
+	reader lineReader
+	// usage adds up the tokens used in the session.
+	usage sessionUsage
+	// messageTokens caches the token count of the messages, which don't
+	// change once added, for the context shown before every prompt.
+	messageTokens map[messageKey]int
+	// pendingTitle is the title being generated in the background.
+	pendingTitle *pendingTitle
+	// journal records the session's messages as they are written.
+	journal sessionJournal
+	// noStreamUsage is set once the server rejected stream_options, so that
+	// streamed replies stop asking for their usage and estimate it instead.
+	noStreamUsage bool
 }
 
-// streamCompletion print out the chat completion in streaming mode.
-func streamCompletion(client *gogpt.Client, request gogpt.ChatCompletionRequest, out io.Writer, verbose bool) error {
-	applyModelSpecificLimitations(&request, verbose)
-	
+// streamResult is what a stream reports besides the text.
+type streamResult struct {
+	Model        string
+	FinishReason string
+	// Usage is only sent when the request sets StreamOptions.IncludeUsage.
+	Usage *gogpt.Usage
+	// FirstToken is when the first text arrived, zero if none did.
+	FirstToken time.Time
+}
+
+// streamCompletion print out the chat completion in streaming mode. The
+// caller applies the model's limitations to the request.
+func streamCompletion(client *gogpt.Client, request gogpt.ChatCompletionRequest, out io.Writer, verbose bool) (streamResult, error) {
+	var result streamResult
 	stream, err := client.CreateChatCompletionStream(context.Background(), request)
 	if err != nil {
-		return err
+		return result, err
 	}
 	defer func() {
 		if closeErr := stream.Close(); closeErr != nil && err == nil {
@@ -52,31 +90,42 @@ func streamCompletion(client *gogpt.Client, request gogpt.ChatCompletionRequest,
 		response, err := stream.Recv()
 		if errors.Is(err, io.EOF) {
 			if _, err := fmt.Fprintln(out); err != nil {
-				return err
+				return result, err
 			}
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("stream recv: %w", err)
+			return result, fmt.Errorf("stream recv: %w", err)
+		}
+		if response.Model != "" {
+			result.Model = response.Model
+		}
+		if response.Usage != nil {
+			result.Usage = response.Usage
 		}
 		if len(response.Choices) == 0 {
-			if verbose {
+			if verbose && response.Usage == nil {
 				log.Println("no choices returned")
 			}
 			continue
 		}
+		if reason := response.Choices[0].FinishReason; reason != "" {
+			result.FinishReason = string(reason)
+		}
+		if response.Choices[0].Delta.Content != "" && result.FirstToken.IsZero() {
+			result.FirstToken = time.Now()
+		}
 		_, err = fmt.Fprint(out, response.Choices[0].Delta.Content)
 		if err != nil {
-			return err
+			return result, err
 		}
 	}
-	return nil
+	return result, nil
 }
 
-// stramCompletion print out the chat completion in non-streaming mode.
+// stramCompletion print out the chat completion in non-streaming mode. The
+// caller applies the model's limitations to the request.
 func nonStreamCompletion(client *gogpt.Client, request gogpt.ChatCompletionRequest, out io.Writer) error {
-	applyModelSpecificLimitations(&request, false)
-	
 	response, err := client.CreateChatCompletion(context.Background(), request)
 	if err != nil {
 		return err
@@ -88,168 +137,156 @@ func nonStreamCompletion(client *gogpt.Client, request gogpt.ChatCompletionReque
 	return err
 }
 
+// promptCompletion prints out the completion for a prompt-mode request,
+// replaying it from the response cache when one is configured.
+func (aiChat *AIChat) promptCompletion(request gogpt.ChatCompletionRequest, out io.Writer) error {
+	applyModelSpecificLimitations(&request, aiChat.options.verbose)
+
+	complete := func(out io.Writer) error {
+		if aiChat.options.nonStreaming {
+			return nonStreamCompletion(aiChat.client, request, out)
+		}
+		_, err := streamCompletion(aiChat.client, request, out, aiChat.options.verbose)
+		return err
+	}
+	if aiChat.cache == nil {
+		return complete(out)
+	}
+
+	key, err := CacheKey(request)
+	if err != nil {
+		return fmt.Errorf("cache key: %w", err)
+	}
+	if output, ok := aiChat.cache.Get(key); ok {
+		if aiChat.options.verbose {
+			log.Printf("cache hit: %s", key)
+		}
+		_, err := fmt.Fprint(out, output)
+		return err
+	}
+
+	var output strings.Builder
+	if err := complete(io.MultiWriter(out, &output)); err != nil {
+		return err
+	}
+	if err := aiChat.cache.Put(key, request.Model, output.String()); err != nil {
+		log.Printf("Failed to store response in cache: %v", err)
+	}
+	return nil
+}
+
+// cachedCompletion returns the reply to a request sent without streaming,
+// from the response cache when one is configured. fold uses it for each part.
+func (aiChat *AIChat) cachedCompletion(request gogpt.ChatCompletionRequest) (string, error) {
+	var key string
+	if aiChat.cache != nil {
+		var err error
+		if key, err = CacheKey(request); err != nil {
+			return "", fmt.Errorf("cache key: %w", err)
+		}
+		if output, ok := aiChat.cache.Get(key); ok {
+			if aiChat.options.verbose {
+				log.Printf("cache hit: %s", key)
+			}
+			return output, nil
+		}
+	}
+	response, err := aiChat.client.CreateChatCompletion(context.Background(), request)
+	if err != nil {
+		return "", fmt.Errorf("create chat completion: %w", err)
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("no choices returned")
+	}
+	output := response.Choices[0].Message.Content
+	if aiChat.cache != nil {
+		if err := aiChat.cache.Put(key, request.Model, output); err != nil {
+			log.Printf("Failed to store response in cache: %v", err)
+		}
+	}
+	return output, nil
+}
+
 func (aiChat *AIChat) stdChatLoop() error {
-	var messages []gogpt.ChatCompletionMessage
-	
 	if aiChat.conversation == nil {
 		aiChat.conversation = NewConversation("New Conversation", aiChat.options.model)
-	} else {
-		// Use messages from loaded conversation
-		messages = aiChat.conversation.ToGPTMessages()
+		aiChat.recordSettings()
 	}
 	
-	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Print("user: ")
-	for scanner.Scan() {
-		input := strings.TrimSpace(scanner.Text())
-		if input == "" {
-			fmt.Println("Empty input. Exiting...")
-			
-			if aiChat.options.saveHistory && len(aiChat.conversation.Messages) > 0 {
-				if err := SaveConversation(aiChat.conversation); err != nil {
-					log.Printf("Failed to save conversation: %v", err)
-				} else if aiChat.options.verbose {
-					log.Printf("Conversation saved with ID: %s", aiChat.conversation.ID)
-				}
-			}
-			
-			return nil
+	aiChat.commands = newCommandRegistry(aiChat.config.Commands)
+	reader, err := newLineReader(newCompleter(aiChat.commands.names()))
+	if err != nil {
+		return err
+	}
+	aiChat.reader = reader
+	defer func() {
+		if closeErr := reader.Close(); closeErr != nil {
+			log.Printf("Failed to close input: %v", closeErr)
 		}
-		
-		if strings.HasPrefix(input, "/") {
-			cmd := strings.TrimSpace(strings.TrimPrefix(input, "/"))
-			switch {
-			case cmd == "save":
-				if len(aiChat.conversation.Messages) == 0 {
-					fmt.Println("No messages to save.")
-				} else {
-					if err := SaveConversation(aiChat.conversation); err != nil {
-						fmt.Printf("Error saving conversation: %v\n", err)
-					} else {
-						fmt.Printf("Conversation saved with ID: %s\n", aiChat.conversation.ID)
-					}
-				}
-				fmt.Print("user: ")
-				continue
-				
-			case cmd == "list":
-				conversations, err := ListConversations()
-				if err != nil {
-					fmt.Printf("Error listing conversations: %v\n", err)
-				} else if len(conversations) == 0 {
-					fmt.Println("No saved conversations.")
-				} else {
-					fmt.Println("Saved conversations:")
-					for _, conv := range conversations {
-						fmt.Printf("- %s: %s (%s)\n", conv.ID, conv.Title, conv.UpdatedAt.Format(time.RFC3339))
-					}
-				}
-				fmt.Print("user: ")
-				continue
-				
-			case strings.HasPrefix(cmd, "load "):
-				id := strings.TrimSpace(strings.TrimPrefix(cmd, "load "))
-				conv, err := LoadConversation(id)
-				if err != nil {
-					fmt.Printf("Error loading conversation: %v\n", err)
-				} else {
-					aiChat.conversation = conv
-					messages = conv.ToGPTMessages()
-					fmt.Printf("Loaded conversation: %s\n", conv.Title)
-				}
-				fmt.Print("user: ")
-				continue
-				
-			case strings.HasPrefix(cmd, "delete "):
-				id := strings.TrimSpace(strings.TrimPrefix(cmd, "delete "))
-				if err := DeleteConversation(id); err != nil {
-					fmt.Printf("Error deleting conversation: %v\n", err)
-				} else {
-					fmt.Println("Conversation deleted.")
-				}
-				fmt.Print("user: ")
-				continue
-				
-			case cmd == "help":
-				fmt.Println("Available commands:")
-				fmt.Println("  /save              - Save the current conversation")
-				fmt.Println("  /list              - List all saved conversations")
-				fmt.Println("  /load <id>         - Load a conversation by ID")
-				fmt.Println("  /delete <id>       - Delete a conversation by ID")
-				fmt.Println("  /help              - Show this help message")
-				fmt.Print("user: ")
-				continue
-			}
+	}()
+
+	for {
+		aiChat.applyPendingTitle(false)
+		input, block, err := readMessage(reader, aiChat.userPrompt())
+		if errors.Is(err, io.EOF) {
+			break
 		}
-		
-		// Add user message to conversation
-		aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, input)
-		
-		messages = append(messages, gogpt.ChatCompletionMessage{
-			Role:    gogpt.ChatMessageRoleUser,
-			Content: input,
-		})
-		
-		fmt.Print("assistant: ")
-		request := gogpt.ChatCompletionRequest{
-			Model:       aiChat.options.model,
-			Messages:    messages,
-			Temperature: aiChat.options.temperature,
-			MaxTokens:   aiChat.options.maxTokens,
+		if errors.Is(err, errInterrupt) {
+			// Ctrl-C abandons the line or block being typed
+			continue
 		}
-		
-		var assistantResponse string
-		var err error
-		
-	if aiChat.options.nonStreaming {
-		applyModelSpecificLimitations(&request, aiChat.options.verbose)
-		
-		response, err := aiChat.client.CreateChatCompletion(context.Background(), request)
-		if err != nil {
+		// commands and @path mentions are only looked for in a line typed at
+		// the prompt
+		typed := !block
+		if errors.Is(err, errOpenEditor) {
+			input = aiChat.composeMessage(input)
+			typed = false
+		} else if err != nil {
 			return err
 		}
-			if len(response.Choices) == 0 {
-				return fmt.Errorf("no choices returned")
+		if input == "" {
+			continue
+		}
+		
+		if typed && strings.HasPrefix(input, "//") {
+			// "//" sends a message starting with "/", such as a path
+			input = input[1:]
+		} else if typed && strings.HasPrefix(input, "/") {
+			message, err := aiChat.commands.run(aiChat, input)
+			if errors.Is(err, errExit) {
+				break
 			}
-			assistantResponse = response.Choices[0].Message.Content
-			fmt.Println(assistantResponse)
-		} else {
-			var responseBuilder strings.Builder
-			
-			writer := io.MultiWriter(os.Stdout, &responseBuilder)
-			
-			err = streamCompletion(aiChat.client, request, writer, aiChat.options.verbose)
 			if err != nil {
-				return err
+				fmt.Printf("Error: %v\n", err)
+			}
+			if message == "" {
+				continue
 			}
-			
-			assistantResponse = responseBuilder.String()
+			input = message
+			typed = false
 		}
 		
-		// Add assistant response to conversation
-		aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, assistantResponse)
-		
-		messages = append(messages, gogpt.ChatCompletionMessage{
-			Role:    gogpt.ChatMessageRoleAssistant,
-			Content: assistantResponse,
-		})
-		
-		if aiChat.conversation.Title == "New Conversation" && len(aiChat.conversation.Messages) == 2 {
-			aiChat.conversation.Title = GetConversationTitle(messages)
+		if err := aiChat.addUserMessage(input, typed); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		if err := aiChat.reply(aiChat.options.temperature); err != nil {
+			return err
 		}
-		
-		fmt.Print("user: ")
 	}
 	
+	aiChat.applyPendingTitle(aiChat.options.saveHistory)
 	if aiChat.options.saveHistory && len(aiChat.conversation.Messages) > 0 {
-		if err := SaveConversation(aiChat.conversation); err != nil {
+		if err := aiChat.saveConversation(); errors.Is(err, errSaveConflict) {
+			log.Printf("Failed to save conversation: %v; its messages are kept in the journal, see --recover %s", err, aiChat.conversation.ID)
+		} else if err != nil {
 			log.Printf("Failed to save conversation: %v", err)
 		} else if aiChat.options.verbose {
 			log.Printf("Conversation saved with ID: %s", aiChat.conversation.ID)
 		}
 	}
 	
-	return scanner.Err()
+	return nil
 }
 
 func firstNonZeroInt(i ...int) int {
@@ -300,14 +337,10 @@ func (aiChat *AIChat) fold(prompt *Prompt, input string) error {
 	
 	applyModelSpecificLimitations(&firstRequest, aiChat.options.verbose)
 	
-	response, err := aiChat.client.CreateChatCompletion(context.Background(), firstRequest)
+	output, err := aiChat.cachedCompletion(firstRequest)
 	if err != nil {
-		return fmt.Errorf("create chat completion: %w", err)
-	}
-	if len(response.Choices) == 0 {
-		return fmt.Errorf("no choices returned")
+		return err
 	}
-	output := response.Choices[0].Message.Content
 	if idx >= len(encoded) {
 		fmt.Println(output)
 		return nil
@@ -343,14 +376,10 @@ func (aiChat *AIChat) fold(prompt *Prompt, input string) error {
 	
 	applyModelSpecificLimitations(&request, aiChat.options.verbose)
 	
-	response, err := aiChat.client.CreateChatCompletion(context.Background(), request)
-	if err != nil {
-			return fmt.Errorf("create chat completion: %w", err)
-		}
-		if len(response.Choices) == 0 {
-			return fmt.Errorf("no choices returned")
+		output, err = aiChat.cachedCompletion(request)
+		if err != nil {
+			return err
 		}
-		output = response.Choices[0].Message.Content
 		if aiChat.options.verbose {
 			log.Printf("subsequent output: %s", output)
 		}
@@ -387,6 +416,24 @@ func hasBetaLimitations(model string) bool {
 	}
 }
 
+// rejectedStreamOptions reports whether err is the server refusing the
+// request for its stream_options, as some OpenAI-compatible servers do with
+// parameters they don't know. Other invalid requests are not retried.
+func rejectedStreamOptions(err error) bool {
+	invalid := func(status int) bool {
+		return status == http.StatusBadRequest || status == http.StatusUnprocessableEntity
+	}
+	var apiErr *gogpt.APIError
+	if errors.As(err, &apiErr) {
+		return invalid(apiErr.HTTPStatusCode) && strings.Contains(apiErr.Message, "stream_options")
+	}
+	var reqErr *gogpt.RequestError
+	if errors.As(err, &reqErr) {
+		return invalid(reqErr.HTTPStatusCode) && strings.Contains(string(reqErr.Body), "stream_options")
+	}
+	return false
+}
+
 func applyModelSpecificLimitations(request *gogpt.ChatCompletionRequest, verbose bool) bool {
 	if !hasBetaLimitations(request.Model) {
 		return false
@@ -405,6 +452,26 @@ func applyModelSpecificLimitations(request *gogpt.ChatCompletionRequest, verbose
 	return true
 }
 
+// closeHistory closes the history store if it was opened.
+func closeHistory() {
+	if err := historyStore.Close(); err != nil {
+		log.Printf("Failed to close history: %v", err)
+	}
+}
+
+// fatal is log.Fatal closing the history store first, as os.Exit skips the
+// deferred close.
+func fatal(v ...any) {
+	closeHistory()
+	log.Fatal(v...)
+}
+
+// fatalf is log.Fatalf closing the history store first.
+func fatalf(format string, v ...any) {
+	closeHistory()
+	log.Fatalf(format, v...)
+}
+
 func main() {
 	var temperature float32 = 0.5
 	var maxTokens = 0
@@ -417,6 +484,30 @@ func main() {
 	var loadHistory = ""
 	var listHistory = false
 	var deleteHistory = ""
+	var useCache = false
+	var noCache = false
+	var chat = false
+	var message = ""
+	var continueLatest = false
+	var search = ""
+	var since = ""
+	var until = ""
+	var migrateHistory = ""
+	var export = ""
+	var format = ""
+	var output = ""
+	var importFile = ""
+	var status = ""
+	var addTags = ""
+	var removeTags = ""
+	var pin = ""
+	var unpin = ""
+	var archive = ""
+	var unarchive = ""
+	var listJournal = false
+	var limit = 0
+	var offset = 0
+	var recoverJournal = ""
 	
 	getopt.FlagLong(&temperature, "temperature", 't', "temperature")
 	getopt.FlagLong(&maxTokens, "max-tokens", 0, "max tokens, 0 to use default")
@@ -426,37 +517,187 @@ func main() {
 	getopt.FlagLong(&split, "split", 0, "split input")
 	getopt.FlagLong(&model, "model", 'm', "model")
 	getopt.FlagLong(&saveHistory, "save", 0, "save conversation history")
-	getopt.FlagLong(&loadHistory, "load", 0, "load conversation history by ID")
-	getopt.FlagLong(&listHistory, "list-history", 0, "list saved conversations")
-	getopt.FlagLong(&deleteHistory, "delete", 0, "delete conversation history by ID")
+	getopt.FlagLong(&loadHistory, "load", 0, "load a conversation by ID, ID prefix or title")
+	getopt.FlagLong(&listHistory, "list-history", 0, "list saved conversations, filtered by --tag, --status, --model, --since and --until, paged by --limit and --offset")
+	getopt.FlagLong(&deleteHistory, "delete", 0, "delete a conversation by ID or ID prefix, or by title after asking")
+	getopt.FlagLong(&useCache, "cache", 0, "cache responses in prompt mode")
+	getopt.FlagLong(&noCache, "no-cache", 0, "bypass the response cache")
+	getopt.FlagLong(&chat, "chat", 0, "start an interactive chat seeded from the prompt")
+	getopt.FlagLong(&message, "message", 0, "send a single message, print the reply and save the conversation")
+	getopt.FlagLong(&continueLatest, "continue", 'c', "continue the most recently updated conversation")
+	getopt.FlagLong(&search, "search", 0, "search saved conversations, filtered by --model, --since and --until")
+	getopt.FlagLong(&since, "since", 0, "only messages on or after the date (YYYY-MM-DD)")
+	getopt.FlagLong(&until, "until", 0, "only messages on or before the date (YYYY-MM-DD)")
+	getopt.FlagLong(&migrateHistory, "migrate-history", 0, "copy saved conversations to the yaml or sqlite store")
+	getopt.FlagLong(&export, "export", 0, "export a conversation by ID or title, or all, filtered by --model, --since and --until")
+	getopt.FlagLong(&format, "format", 0, "export format: md, json, html or jsonl; list format: table or json")
+	getopt.FlagLong(&output, "output", 'o', "write the export to a file")
+	getopt.FlagLong(&importFile, "import", 0, "import conversations from ChatGPT's conversations.json or OpenAI-style JSON/JSONL, - for stdin")
+	tags := getopt.ListLong("tag", 0, "only conversations with the tag, or the tags for --add-tags and --remove-tags")
+	getopt.FlagLong(&status, "status", 0, "list active, pinned, archived or all conversations (default active)")
+	getopt.FlagLong(&addTags, "add-tags", 0, "add the --tag tags to a conversation by ID or title")
+	getopt.FlagLong(&removeTags, "remove-tags", 0, "remove the --tag tags from a conversation by ID or title")
+	getopt.FlagLong(&pin, "pin", 0, "pin a conversation by ID or title")
+	getopt.FlagLong(&unpin, "unpin", 0, "unpin a conversation by ID or title")
+	getopt.FlagLong(&archive, "archive", 0, "archive a conversation by ID or title")
+	getopt.FlagLong(&unarchive, "unarchive", 0, "unarchive a conversation by ID or title")
+	getopt.FlagLong(&limit, "limit", 0, "list at most this many conversations, 0 for all")
+	getopt.FlagLong(&offset, "offset", 0, "skip this many conversations of the list")
+	getopt.FlagLong(&listJournal, "list-journal", 0, "list the journals of recent sessions, saved or not")
+	getopt.FlagLong(&recoverJournal, "recover", 0, "save the conversation recorded in a journal by ID or ID prefix")
 	getopt.Parse()
 
 	if listPrompts {
 		if err := ListPrompts(); err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		return
 	}
 	
+	if listJournal {
+		if err := printJournals(os.Stdout); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	config, err := ReadConfig()
+	if err != nil {
+		fatal(err)
+	}
+	// the store is opened by the first command that needs it
+	historyStore = &lazyStore{open: func() (HistoryStore, error) {
+		return OpenHistoryStore(config.History, config.History.Store)
+	}}
+	defer closeHistory()
+
+	if migrateHistory != "" {
+		if migrateHistory == cmp.Or(config.History.Store, "yaml") {
+			fatalf("history is already kept in the %s store", migrateHistory)
+		}
+		to, err := OpenHistoryStore(config.History, migrateHistory)
+		if err != nil {
+			fatal(err)
+		}
+		n, err := MigrateHistory(historyStore, to)
+		if closeErr := to.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			fatalf("Failed to migrate history: %v", err)
+		}
+		fmt.Printf("Copied %d conversations to the %s store.\n", n, migrateHistory)
+		return
+	}
+
+	if recoverJournal != "" {
+		conv, added, err := RecoverJournal(recoverJournal)
+		if err != nil {
+			fatalf("Failed to recover: %v", err)
+		}
+		if added == 0 {
+			fmt.Printf("Conversation %s is already saved.\n", conv.ID)
+		} else {
+			fmt.Printf("Recovered %d messages of %q with ID: %s\n", added, conv.Title, conv.ID)
+		}
+		return
+	}
+
+	var sinceTime, untilTime time.Time
+	if since != "" {
+		if sinceTime, err = parseDate(since, false); err != nil {
+			fatal(err)
+		}
+	}
+	if until != "" {
+		if untilTime, err = parseDate(until, true); err != nil {
+			fatal(err)
+		}
+	}
+
 	if listHistory {
-		conversations, err := ListConversations()
+		if status, err = parseStatus(cmp.Or(status, statusActive)); err != nil {
+			fatal(err)
+		}
+		query := listQuery{
+			ConversationFilter: ConversationFilter{Model: model, Since: sinceTime, Until: untilTime, Tags: *tags, Status: status},
+			Limit:              limit,
+			Offset:             offset,
+		}
+		if err := printHistory(os.Stdout, query, cmp.Or(format, "table")); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	updates := []struct {
+		query   string
+		change  func(*Conversation)
+		message string // empty to print the tags
+	}{
+		{addTags, func(conv *Conversation) { conv.AddTags(*tags...) }, ""},
+		{removeTags, func(conv *Conversation) { conv.RemoveTags(*tags...) }, ""},
+		{pin, func(conv *Conversation) { conv.Pinned = true }, "Conversation pinned."},
+		{unpin, func(conv *Conversation) { conv.Pinned = false }, "Conversation unpinned."},
+		{archive, func(conv *Conversation) { conv.Archived = true }, "Conversation archived."},
+		{unarchive, func(conv *Conversation) { conv.Archived = false }, "Conversation unarchived."},
+	}
+	updated := false
+	for _, update := range updates {
+		if update.query == "" {
+			continue
+		}
+		conv, err := UpdateSavedConversation(update.query, update.change, terminalChooser())
 		if err != nil {
-			log.Fatal(err)
+			fatalf("Failed to update conversation: %v", err)
 		}
-		if len(conversations) == 0 {
-			fmt.Println("No saved conversations.")
+		if update.message == "" {
+			fmt.Printf("Tags: %s\n", strings.Join(conv.Tags, ", "))
 		} else {
-			fmt.Println("Saved conversations:")
-			for _, conv := range conversations {
-				fmt.Printf("- %s: %s (%s)\n", conv.ID, conv.Title, conv.UpdatedAt.Format(time.RFC3339))
-			}
+			fmt.Println(update.message)
+		}
+		updated = true
+	}
+	if updated {
+		return
+	}
+
+	if search != "" {
+		hits, err := SearchConversations(searchQuery{Text: search, Model: model, Since: sinceTime, Until: untilTime})
+		if err != nil {
+			fatal(err)
+		}
+		printSearchHits(hits)
+		return
+	}
+	
+	if export != "" {
+		conversations, err := conversationsToExport(export, ConversationFilter{Model: model, Since: sinceTime, Until: untilTime}, terminalChooser())
+		if err != nil {
+			fatal(err)
+		}
+		if err := exportFile(output, conversations, cmp.Or(format, "md")); err != nil {
+			fatalf("Failed to export: %v", err)
+		}
+		return
+	}
+	
+	if importFile != "" {
+		summary, err := ImportFile(importFile)
+		if err != nil {
+			fatalf("Failed to import: %v", err)
 		}
+		fmt.Println(summary)
 		return
 	}
 	
 	if deleteHistory != "" {
-		if err := DeleteConversation(deleteHistory); err != nil {
-			log.Fatalf("Failed to delete conversation: %v", err)
+		id, err := FindConversationToDelete(deleteHistory, terminalChooser(), terminalConfirm())
+		if err != nil {
+			fatalf("Failed to delete conversation: %v", err)
+		}
+		if err := DeleteConversation(id); err != nil {
+			fatalf("Failed to delete conversation: %v", err)
 		}
 		fmt.Println("Conversation deleted.")
 		return
@@ -464,12 +705,7 @@ func main() {
 
 	openaiAPIKey, err := ReadOpenAIAPIKey()
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	config, err := ReadConfig()
-	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 
 	// if model is not specified, use the default model from the config file
@@ -497,44 +733,111 @@ func main() {
 	}
 	encoder, err := tokenizer.NewEncoder()
 	if err != nil {
-		log.Fatal(err)
+		fatal(err)
 	}
 	
 	aiChat := AIChat{
-		client:  gogpt.NewClient(openaiAPIKey),
-		encoder: encoder,
-		options: options,
+		client:   gogpt.NewClient(openaiAPIKey),
+		encoder:  encoder,
+		options:  options,
+		defaults: options,
+		config:   *config,
+	}
+
+	if (useCache || config.Cache.Enabled) && !noCache {
+		cache, err := NewResponseCache(config.Cache)
+		if err != nil {
+			fatal(err)
+		}
+		aiChat.cache = cache
+	}
+	
+	if continueLatest {
+		if loadHistory, err = LatestConversationID(); err != nil {
+			fatalf("Failed to continue: %v", err)
+		}
+	}
+	// --message, or --continue with piped input, runs a single turn for scripts
+	stdinIsTerminal := readline.IsTerminal(int(os.Stdin.Fd()))
+	singleTurn := message != "" || (continueLatest && !stdinIsTerminal)
+	if singleTurn && !stdinIsTerminal {
+		if input := strings.TrimSpace(scanAll(bufio.NewScanner(os.Stdin))); input != "" {
+			message = strings.TrimSpace(message + "\n\n" + input)
+		}
 	}
 	
 	if loadHistory != "" {
-		conversation, err := LoadConversation(loadHistory)
+		id, err := FindConversationID(loadHistory, terminalChooser())
 		if err != nil {
-			log.Fatalf("Failed to load conversation: %v", err)
+			fatalf("Failed to load conversation: %v", err)
+		}
+		conversation, err := LoadConversation(id)
+		if err != nil {
+			fatalf("Failed to load conversation: %v", err)
+		}
+		aiChat.setConversation(conversation)
+		// options given on the command line take precedence over the saved settings
+		if getopt.IsSet("model") {
+			aiChat.options.model = model
+		}
+		if getopt.IsSet("temperature") {
+			aiChat.options.temperature = temperature
+		}
+		if getopt.IsSet("max-tokens") {
+			aiChat.options.maxTokens = maxTokens
+		}
+		if getopt.IsSet("non-streaming") {
+			aiChat.options.nonStreaming = nonStreaming
+		}
+		aiChat.recordSettings()
+		if !singleTurn {
+			fmt.Printf("Loaded conversation: %s\n", conversation.Title)
+		}
+	}
+
+	if singleTurn {
+		if message == "" {
+			fatal("no message to send")
+		}
+		if err := aiChat.singleTurn(message, os.Stdout); err != nil {
+			fatal(err)
 		}
-		aiChat.conversation = conversation
-		fmt.Printf("Loaded conversation: %s\n", conversation.Title)
+		return
 	}
 
 	args := getopt.Args()
 	if len(args) == 0 {
 		if err := aiChat.stdChatLoop(); err != nil {
-			log.Fatal(err)
+			fatal(err)
+		}
+	} else if chat {
+		prompts, err := ReadPrompts()
+		if err != nil {
+			fatal(err)
+		}
+		prompt := prompts[args[0]]
+		if prompt == nil {
+			fatalf("prompt %q not found", args[0])
+		}
+		aiChat.startPrompt(args[0], prompt)
+		if err := aiChat.stdChatLoop(); err != nil {
+			fatal(err)
 		}
 	} else {
 		prompts, err := ReadPrompts()
 		if err != nil {
-			log.Fatal(err)
+			fatal(err)
 		}
 		prompt := prompts[args[0]]
 		if prompt == nil {
-			log.Fatalf("prompt %q not found", args[0])
+			fatalf("prompt %q not found", args[0])
 		}
 		// read all from Stdin
 		input := scanAll(bufio.NewScanner(os.Stdin))
 
 		if prompt.isFoldEnabled() {
 			if err := aiChat.fold(prompt, input); err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 			return
 		}
@@ -545,7 +848,7 @@ func main() {
 		if split {
 			messagesSlice, err = prompt.CreateMessagesWithSplit(aiChat.encoder, input, tokenLimit, aiChat.options.maxTokens, aiChat.options.verbose)
 			if err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 			if verbose {
 				log.Printf("messages was split to %d parts", len(messagesSlice))
@@ -575,24 +878,17 @@ func main() {
 
 			cnt, err := CountTokens(mapSlice(messages, func(m gogpt.ChatCompletionMessage) string { return m.Content }))
 			if err != nil {
-				log.Fatal(err)
+				fatal(err)
 			}
 			if verbose {
 				log.Printf("total tokens %d", cnt)
 			}
 			if cnt+maxTokens > tokenLimit {
-				log.Fatalf("total tokens %d exceeds %d", cnt, tokenLimit)
+				fatalf("total tokens %d exceeds %d", cnt, tokenLimit)
 			}
 
-			applyModelSpecificLimitations(&request, aiChat.options.verbose)
-			
-			if aiChat.options.nonStreaming {
-				err = nonStreamCompletion(aiChat.client, request, os.Stdout)
-			} else {
-				err = streamCompletion(aiChat.client, request, os.Stdout, aiChat.options.verbose)
-			}
-			if err != nil {
-				log.Fatal(err)
+			if err := aiChat.promptCompletion(request, os.Stdout); err != nil {
+				fatal(err)
 			}
 		}
 	}
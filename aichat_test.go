@@ -1,8 +1,10 @@
 package main
 
 import (
+	"path/filepath"
 	"testing"
 
+	tokenizer "github.com/samber/go-gpt-3-encoder"
 	gogpt "github.com/sashabaranov/go-openai"
 )
 
@@ -116,3 +118,24 @@ func TestApplyModelSpecificLimitations(t *testing.T) {
 		t.Errorf("Temperature should remain 0.7, got %v", request.Temperature)
 	}
 }
+
+func TestFoldCache(t *testing.T) {
+	prompt, err := NewPromptFromFile(filepath.Join("testdata", "fold.yml"))
+	if err != nil {
+		t.Fatalf("Failed to read the prompt: %v", err)
+	}
+	aiChat, requests := newTestAIChat(t, "A summary.")
+	if aiChat.encoder, err = tokenizer.NewEncoder(); err != nil {
+		t.Fatal(err)
+	}
+	aiChat.cache = newTestCache(t, CacheConfig{Enabled: true})
+
+	for i := 0; i < 2; i++ {
+		if err := aiChat.fold(prompt, "Some text to summarize."); err != nil {
+			t.Fatalf("fold() returned an error: %v", err)
+		}
+	}
+	if len(*requests) != 1 {
+		t.Errorf("Expected the second run to be answered from the cache, got %d requests", len(*requests))
+	}
+}
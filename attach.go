@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// attachment is a file whose contents are sent along with the next message.
+type attachment struct {
+	path    string
+	content string
+}
+
+// binarySniffLen is how much of a file is checked for NUL bytes to tell
+// binary files from text, as git does.
+const binarySniffLen = 8000
+
+// loadAttachments resolves paths, globs and directories to the text files
+// they name and reads them. Directories are walked recursively, skipping
+// files ignored by .gitignore. Binary files are skipped.
+func loadAttachments(patterns []string) ([]attachment, error) {
+	paths, err := expandAttachmentPaths(patterns)
+	if err != nil {
+		return nil, err
+	}
+	var attachments []attachment
+	for _, path := range paths {
+		content, binary, err := readTextFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if binary {
+			continue
+		}
+		attachments = append(attachments, attachment{path: path, content: content})
+	}
+	return attachments, nil
+}
+
+func expandAttachmentPaths(patterns []string) ([]string, error) {
+	var paths []string
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s: no such file or directory", pattern)
+		}
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+			files, err := walkDir(match)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				add(file)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// walkDir returns the regular files below dir that are not ignored by git.
+func walkDir(dir string) ([]string, error) {
+	ignore, err := newGitIgnore(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if path != dir && ignore.Match(path, true) {
+				return filepath.SkipDir
+			}
+			return ignore.Load(path)
+		}
+		if d.Type().IsRegular() && !ignore.Match(path, false) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// readTextFile reads the file and reports whether it looks binary.
+func readTextFile(path string) (string, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	sniff := data
+	if len(sniff) > binarySniffLen {
+		sniff = sniff[:binarySniffLen]
+	}
+	if bytes.IndexByte(sniff, 0) >= 0 {
+		return "", true, nil
+	}
+	return string(data), false, nil
+}
+
+// formatAttachment returns the file as a fenced code block labelled with its
+// name. The fence is made longer than any backtick run in the content.
+func formatAttachment(a attachment) string {
+	fence := "```"
+	for strings.Contains(a.content, fence) {
+		fence += "`"
+	}
+	return fmt.Sprintf("%s%s\n%s\n%s", fence, filepath.ToSlash(a.path), strings.TrimRight(a.content, "\n"), fence)
+}
+
+// withAttachments appends the attachments to the message as fenced blocks.
+func withAttachments(message string, attachments []attachment) string {
+	parts := []string{message}
+	for _, a := range attachments {
+		parts = append(parts, formatAttachment(a))
+	}
+	return strings.TrimSpace(strings.Join(parts, "\n\n"))
+}
+
+// prepareMessage adds the files attached with /file and the mentioned files
+// to the message, and checks that the conversation still fits in the model's
+// context. It returns the message and the paths of the attached files.
+func (aiChat *AIChat) prepareMessage(input string, mentions []string) (string, []string, error) {
+	attachments := aiChat.attachments
+	if len(mentions) > 0 {
+		mentioned, err := loadAttachments(mentions)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, a := range mentioned {
+			count, err := aiChat.countTokens(a.content)
+			if err != nil {
+				return "", nil, err
+			}
+			fmt.Printf("Attached %s (%d tokens)\n", a.path, count)
+		}
+		attachments = append(attachments[:len(attachments):len(attachments)], mentioned...)
+	}
+	if len(attachments) == 0 {
+		return input, nil, nil
+	}
+
+	content := withAttachments(input, attachments)
+	contents := mapSlice(aiChat.conversation.Path(), func(m ChatMessage) string { return m.Content })
+	count := 0
+	for _, text := range append(contents, content) {
+		n, err := aiChat.countTokens(text)
+		if err != nil {
+			return "", nil, err
+		}
+		count += n
+	}
+	limit := tokenLimitOfModel(aiChat.options.model)
+	if count+aiChat.options.maxTokens > limit {
+		return "", nil, fmt.Errorf("the conversation with the attached files has %d tokens, which exceeds the limit of %d for %s",
+			count, limit, aiChat.options.model)
+	}
+	aiChat.attachments = nil
+	return content, mapSlice(attachments, func(a attachment) string { return a.path }), nil
+}
+
+// attachFiles adds files to be sent with the next message.
+func (aiChat *AIChat) attachFiles(patterns []string) error {
+	attachments, err := loadAttachments(patterns)
+	if err != nil {
+		return err
+	}
+	if len(attachments) == 0 {
+		return fmt.Errorf("no text files found")
+	}
+	for _, a := range attachments {
+		count, err := aiChat.countTokens(a.content)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Attached %s (%d tokens)\n", a.path, count)
+	}
+	aiChat.attachments = append(aiChat.attachments, attachments...)
+	return nil
+}
+
+// mentionPattern matches @path mentions at the start of a word.
+var mentionPattern = regexp.MustCompile(`(?:^|\s)@(\S+)`)
+
+// findMentions returns the @path mentions in the message that name existing
+// files, directories or glob matches. Trailing punctuation is not part of a path.
+// Only lines typed at the prompt are searched, never pasted blocks, command
+// output or replies, so that no file is sent without the user naming it.
+func findMentions(message string) []string {
+	var paths []string
+	for _, match := range mentionPattern.FindAllStringSubmatch(message, -1) {
+		path := strings.TrimRight(match[1], ".,;:!?)\"'")
+		if matches, err := filepath.Glob(path); err == nil && len(matches) > 0 {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// gitIgnore matches paths against the .gitignore files from the repository
+// root down to the directory being walked.
+type gitIgnore struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	base    string
+	pattern *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// newGitIgnore loads the .gitignore files of dir's ancestors inside the same
+// repository. The walk loads the ones below it with Load.
+func newGitIgnore(dir string) (*gitIgnore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ancestors []string
+	if _, err := os.Stat(filepath.Join(abs, ".git")); err != nil {
+		for d := filepath.Dir(abs); ; d = filepath.Dir(d) {
+			ancestors = append(ancestors, d)
+			if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+				break
+			}
+			if filepath.Dir(d) == d {
+				// not in a repository; only the walked directory's files apply
+				ancestors = nil
+				break
+			}
+		}
+	}
+	ignore := &gitIgnore{}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if err := ignore.loadFile(ancestors[i]); err != nil {
+			return nil, err
+		}
+	}
+	return ignore, nil
+}
+
+// Load adds the rules of dir's .gitignore, if there is one.
+func (g *gitIgnore) Load(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	return g.loadFile(abs)
+}
+
+func (g *gitIgnore) loadFile(dir string) error {
+	file, err := os.Open(filepath.Join(dir, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	return g.parse(dir, file)
+}
+
+func (g *gitIgnore) parse(base string, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		expr := ignorePatternToRegexp(line)
+		if !anchored {
+			expr = "(?:.*/)?" + expr
+		}
+		pattern, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			continue
+		}
+		rule.pattern = pattern
+		g.rules = append(g.rules, rule)
+	}
+	return scanner.Err()
+}
+
+func ignorePatternToRegexp(pattern string) string {
+	var expr strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "/**") && i+3 == len(pattern):
+			expr.WriteString("/.*")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case c == '*':
+			expr.WriteString("[^/]*")
+		case c == '?':
+			expr.WriteString("[^/]")
+		case c == '[':
+			if end := strings.IndexByte(pattern[i:], ']'); end > 0 {
+				expr.WriteString(strings.Replace(pattern[i:i+end+1], "[!", "[^", 1))
+				i += end
+			} else {
+				expr.WriteString(`\[`)
+			}
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// Match reports whether path is ignored. The last matching rule wins.
+func (g *gitIgnore) Match(path string, isDir bool) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+	ignored := false
+	for _, rule := range g.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel, err := filepath.Rel(rule.base, abs)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if rule.pattern.MatchString(filepath.ToSlash(rel)) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
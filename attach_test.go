@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatalf("Failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+}
+
+func TestLoadAttachments(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ".git"), 0700); err != nil {
+		t.Fatalf("Failed to create .git: %v", err)
+	}
+	writeTestFiles(t, dir, map[string]string{
+		".gitignore":          "*.log\n/build/\n!keep.log\n",
+		"main.go":             "package main\n",
+		"debug.log":           "noise",
+		"keep.log":            "kept",
+		"build/out.txt":       "built",
+		"src/util.go":         "package src\n",
+		"src/.gitignore":      "gen_*.go\n",
+		"src/gen_types.go":    "generated",
+		"src/image.png":       "\x89PNG\x00\x00",
+		"src/nested/build/x":  "not at the root, so not ignored",
+		".git/config":         "[core]",
+		"docs/readme.md":      "docs",
+		"docs/other/notes.md": "notes",
+	})
+
+	attachments, err := loadAttachments([]string{dir})
+	if err != nil {
+		t.Fatalf("loadAttachments() returned an error: %v", err)
+	}
+	var got []string
+	for _, a := range attachments {
+		rel, _ := filepath.Rel(dir, a.path)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	expected := []string{
+		".gitignore",
+		"docs/other/notes.md",
+		"docs/readme.md",
+		"keep.log",
+		"main.go",
+		"src/.gitignore",
+		"src/nested/build/x",
+		"src/util.go",
+	}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	attachments, err = loadAttachments([]string{filepath.Join(dir, "docs", "*.md"), filepath.Join(dir, "debug.log")})
+	if err != nil {
+		t.Fatalf("loadAttachments() returned an error: %v", err)
+	}
+	if len(attachments) != 2 || attachments[1].content != "noise" {
+		t.Errorf("Expected the glob match and the explicitly named file, got %+v", attachments)
+	}
+
+	attachments, err = loadAttachments([]string{filepath.Join(dir, "src")})
+	if err != nil {
+		t.Fatalf("loadAttachments() returned an error: %v", err)
+	}
+	for _, a := range attachments {
+		if strings.HasSuffix(a.path, ".log") || strings.Contains(a.path, "gen_") {
+			t.Errorf("Expected ignored file not to be attached: %s", a.path)
+		}
+	}
+
+	if _, err := loadAttachments([]string{filepath.Join(dir, "missing.go")}); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestFormatAttachment(t *testing.T) {
+	got := formatAttachment(attachment{path: "main.go", content: "package main\n"})
+	if got != "```main.go\npackage main\n```" {
+		t.Errorf("Unexpected block: %q", got)
+	}
+	got = formatAttachment(attachment{path: "README.md", content: "```sh\nls\n```\n"})
+	if got != "````README.md\n```sh\nls\n```\n````" {
+		t.Errorf("Expected a longer fence around backticks: %q", got)
+	}
+}
+
+func TestFindMentions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"main.go": "package main\n"})
+	path := filepath.Join(dir, "main.go")
+
+	got := findMentions("Please review @" + path + ", and mail me@example.com about @missing.go")
+	if len(got) != 1 || got[0] != path {
+		t.Errorf("Expected only the existing file, got %q", got)
+	}
+}
+
+func TestPrepareMessage(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.txt":   "alpha",
+		"big.txt": strings.Repeat("word ", 5000),
+	})
+
+	aiChat := &AIChat{
+		options:      chatOptions{model: "gpt-3.5-turbo"},
+		conversation: NewConversation("Test", "gpt-3.5-turbo"),
+	}
+	if err := aiChat.attachFiles([]string{filepath.Join(dir, "a.txt")}); err != nil {
+		t.Fatalf("attachFiles() returned an error: %v", err)
+	}
+	content, attached, err := aiChat.prepareMessage("What is this?", nil)
+	if err != nil {
+		t.Fatalf("prepareMessage() returned an error: %v", err)
+	}
+	if !strings.HasPrefix(content, "What is this?\n\n```") || !strings.Contains(content, "alpha") {
+		t.Errorf("Expected the file to follow the message, got %q", content)
+	}
+	if len(attached) != 1 || len(aiChat.attachments) != 0 {
+		t.Errorf("Expected the attachment to be recorded and cleared, got %q, %d pending", attached, len(aiChat.attachments))
+	}
+
+	content, attached, err = aiChat.prepareMessage("No files", nil)
+	if err != nil || content != "No files" || attached != nil {
+		t.Errorf("Expected the message unchanged, got %q %q %v", content, attached, err)
+	}
+
+	if _, _, err := aiChat.prepareMessage("Summarize", []string{filepath.Join(dir, "big.txt")}); err == nil {
+		t.Error("Expected the token limit to be exceeded")
+	}
+
+	// only a typed line has its mentions attached
+	mention := "What is @" + filepath.Join(dir, "a.txt")
+	if err := aiChat.addUserMessage(mention, false); err != nil {
+		t.Fatalf("addUserMessage() returned an error: %v", err)
+	}
+	if got := aiChat.conversation.Path(); got[len(got)-1].Content != mention {
+		t.Errorf("Expected a mention in pasted text to be left alone, got %q", got[len(got)-1].Content)
+	}
+	if err := aiChat.addUserMessage(mention, true); err != nil {
+		t.Fatalf("addUserMessage() returned an error: %v", err)
+	}
+	if got := aiChat.conversation.Path(); !strings.Contains(got[len(got)-1].Content, "alpha") {
+		t.Errorf("Expected a typed mention to attach the file, got %q", got[len(got)-1].Content)
+	}
+
+	// a message that doesn't fit keeps the template for the next try
+	aiChat.inputTemplate = func(input string) string { return "Translate: " + input }
+	if err := aiChat.addUserMessage("@"+filepath.Join(dir, "big.txt"), true); err == nil {
+		t.Error("Expected the token limit to be exceeded")
+	}
+	if err := aiChat.addUserMessage("Hello", true); err != nil || aiChat.inputTemplate != nil {
+		t.Fatalf("Expected the template to be used up, got %v", err)
+	}
+	if got := aiChat.conversation.Path(); got[len(got)-1].Content != "Translate: Hello" {
+		t.Errorf("Expected the template to apply to the retried message, got %q", got[len(got)-1].Content)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	gogpt "github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultCacheTTL          = 7 * 24 * time.Hour
+	defaultCacheMaxEntrySize = 1 << 20
+	defaultCacheMaxSize      = 100 << 20
+)
+
+type cacheEntry struct {
+	Key       string    `yaml:"key"`
+	Model     string    `yaml:"model"`
+	CreatedAt time.Time `yaml:"created_at"`
+	Output    string    `yaml:"output"`
+}
+
+// ResponseCache stores completion outputs on disk, keyed by a hash of the request.
+type ResponseCache struct {
+	dir          string
+	ttl          time.Duration
+	maxEntrySize int64
+	maxSize      int64
+}
+
+type GetCacheDirFunc func() (string, error)
+
+var GetCacheDir GetCacheDirFunc = func() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	cacheDir := filepath.Join(homeDir, ".aichat", "cache")
+
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return "", err
+	}
+
+	return cacheDir, nil
+}
+
+// NewResponseCache returns a cache in the cache directory, filling unset limits with defaults.
+func NewResponseCache(config CacheConfig) (*ResponseCache, error) {
+	dir, err := GetCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	cache := &ResponseCache{
+		dir:          dir,
+		ttl:          config.TTL,
+		maxEntrySize: config.MaxEntrySize,
+		maxSize:      config.MaxSize,
+	}
+	if cache.ttl == 0 {
+		cache.ttl = defaultCacheTTL
+	}
+	if cache.maxEntrySize == 0 {
+		cache.maxEntrySize = defaultCacheMaxEntrySize
+	}
+	if cache.maxSize == 0 {
+		cache.maxSize = defaultCacheMaxSize
+	}
+	return cache, nil
+}
+
+// CacheKey hashes the parts of the request that determine the response:
+// the model, the rendered messages and the sampling parameters.
+func CacheKey(request gogpt.ChatCompletionRequest) (string, error) {
+	data, err := json.Marshal(struct {
+		Model            string                        `json:"model"`
+		Messages         []gogpt.ChatCompletionMessage `json:"messages"`
+		Temperature      float32                       `json:"temperature"`
+		TopP             float32                       `json:"top_p"`
+		N                int                           `json:"n"`
+		MaxTokens        int                           `json:"max_tokens"`
+		PresencePenalty  float32                       `json:"presence_penalty"`
+		FrequencyPenalty float32                       `json:"frequency_penalty"`
+		Stop             []string                      `json:"stop"`
+	}{
+		Model:            request.Model,
+		Messages:         request.Messages,
+		Temperature:      request.Temperature,
+		TopP:             request.TopP,
+		N:                request.N,
+		MaxTokens:        request.MaxTokens,
+		PresencePenalty:  request.PresencePenalty,
+		FrequencyPenalty: request.FrequencyPenalty,
+		Stop:             request.Stop,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (c *ResponseCache) path(key string) string {
+	return filepath.Join(c.dir, key+".yml")
+}
+
+// Get returns the cached output for key. Expired entries are removed and reported as misses.
+func (c *ResponseCache) Get(key string) (string, bool) {
+	entry := &cacheEntry{}
+	if err := ReadYamlFromFile(c.path(key), entry); err != nil {
+		return "", false
+	}
+	if entry.Key != key {
+		return "", false
+	}
+	if time.Since(entry.CreatedAt) > c.ttl {
+		_ = os.Remove(c.path(key))
+		return "", false
+	}
+	return entry.Output, true
+}
+
+// Put stores output under key and prunes the cache back under its size limit.
+// Outputs larger than the per-entry limit are not stored.
+func (c *ResponseCache) Put(key, model, output string) error {
+	if int64(len(output)) > c.maxEntrySize {
+		return nil
+	}
+	data, err := yaml.Marshal(&cacheEntry{
+		Key:       key,
+		Model:     model,
+		CreatedAt: time.Now(),
+		Output:    output,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.path(key), data, 0600); err != nil {
+		return err
+	}
+	return c.prune()
+}
+
+// prune removes expired entries, then the oldest ones until the cache fits in maxSize.
+func (c *ResponseCache) prune() error {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+
+	type cacheFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []cacheFile
+	var total int64
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".yml") {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.dir, file.Name())
+		if time.Since(info.ModTime()) > c.ttl {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("remove expired cache entry: %w", err)
+			}
+			continue
+		}
+		entries = append(entries, cacheFile{path: path, size: info.Size(), modTime: info.ModTime()})
+		total += info.Size()
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+	for _, entry := range entries {
+		if total <= c.maxSize {
+			break
+		}
+		if err := os.Remove(entry.path); err != nil {
+			return fmt.Errorf("remove cache entry: %w", err)
+		}
+		total -= entry.size
+	}
+	return nil
+}
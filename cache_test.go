@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func newTestCache(t *testing.T, config CacheConfig) *ResponseCache {
+	tempDir, err := os.MkdirTemp("", "aichat-cache-test")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			t.Logf("Failed to remove temp dir: %v", err)
+		}
+	})
+
+	origGetCacheDir := GetCacheDir
+	t.Cleanup(func() { GetCacheDir = origGetCacheDir })
+	GetCacheDir = func() (string, error) {
+		return tempDir, nil
+	}
+
+	cache, err := NewResponseCache(config)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	return cache
+}
+
+func TestCacheKey(t *testing.T) {
+	request := gogpt.ChatCompletionRequest{
+		Model:       "gpt-4",
+		Messages:    []gogpt.ChatCompletionMessage{{Role: "user", Content: "Hello"}},
+		Temperature: 0.5,
+	}
+	key1, err := CacheKey(request)
+	if err != nil {
+		t.Fatalf("CacheKey() returned an error: %v", err)
+	}
+	key2, _ := CacheKey(request)
+	if key1 != key2 {
+		t.Errorf("Expected identical requests to have the same key, got %q and %q", key1, key2)
+	}
+
+	request.Stream = true
+	key3, _ := CacheKey(request)
+	if key1 != key3 {
+		t.Error("Expected streaming mode not to affect the key")
+	}
+
+	request.Temperature = 0.7
+	key4, _ := CacheKey(request)
+	if key1 == key4 {
+		t.Error("Expected temperature to affect the key")
+	}
+
+	request.Temperature = 0.5
+	request.Messages = []gogpt.ChatCompletionMessage{{Role: "user", Content: "Hello!"}}
+	key5, _ := CacheKey(request)
+	if key1 == key5 {
+		t.Error("Expected messages to affect the key")
+	}
+}
+
+func TestResponseCacheGetPut(t *testing.T) {
+	cache := newTestCache(t, CacheConfig{})
+
+	if _, ok := cache.Get("missing"); ok {
+		t.Error("Expected miss for unknown key")
+	}
+
+	if err := cache.Put("key", "gpt-4", "Hello there!\n"); err != nil {
+		t.Fatalf("Put() returned an error: %v", err)
+	}
+	output, ok := cache.Get("key")
+	if !ok {
+		t.Fatal("Expected hit after Put()")
+	}
+	if output != "Hello there!\n" {
+		t.Errorf("Expected output %q, got %q", "Hello there!\n", output)
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	cache := newTestCache(t, CacheConfig{TTL: time.Millisecond})
+
+	if err := cache.Put("key", "gpt-4", "output"); err != nil {
+		t.Fatalf("Put() returned an error: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := cache.Get("key"); ok {
+		t.Error("Expected expired entry to be a miss")
+	}
+	if _, err := os.Stat(cache.path("key")); !os.IsNotExist(err) {
+		t.Error("Expected expired entry to be removed")
+	}
+}
+
+func TestResponseCacheSizeLimits(t *testing.T) {
+	cache := newTestCache(t, CacheConfig{MaxEntrySize: 10})
+
+	if err := cache.Put("big", "gpt-4", strings.Repeat("x", 11)); err != nil {
+		t.Fatalf("Put() returned an error: %v", err)
+	}
+	if _, ok := cache.Get("big"); ok {
+		t.Error("Expected oversized entry not to be stored")
+	}
+
+	cache = newTestCache(t, CacheConfig{MaxSize: 300})
+	for _, key := range []string{"first", "second", "third"} {
+		if err := cache.Put(key, "gpt-4", strings.Repeat("x", 100)); err != nil {
+			t.Fatalf("Put() returned an error: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := cache.Get("first"); ok {
+		t.Error("Expected oldest entry to be pruned")
+	}
+	if _, ok := cache.Get("third"); !ok {
+		t.Error("Expected newest entry to be kept")
+	}
+}
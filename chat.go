@@ -0,0 +1,394 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// reply sends the conversation to the model, prints the answer and appends it
+// to the conversation.
+func (aiChat *AIChat) reply(temperature float32) error {
+	fmt.Print("assistant: ")
+	return aiChat.generateReply(temperature, os.Stdout)
+}
+
+// generateReply is reply without the role label, writing the answer to out.
+func (aiChat *AIChat) generateReply(temperature float32, out io.Writer) error {
+	request := gogpt.ChatCompletionRequest{
+		Model:       aiChat.options.model,
+		Messages:    aiChat.conversation.ToGPTMessages(),
+		Temperature: temperature,
+		MaxTokens:   aiChat.options.maxTokens,
+	}
+	applyModelSpecificLimitations(&request, aiChat.options.verbose)
+	aiChat.writeJournal()
+	metadata := &MessageMetadata{
+		Model:       request.Model,
+		Temperature: request.Temperature,
+		MaxTokens:   request.MaxTokens,
+		Streamed:    !aiChat.options.nonStreaming,
+	}
+
+	var assistantResponse string
+	var usage *gogpt.Usage
+	start := time.Now()
+	if aiChat.options.nonStreaming {
+		response, err := aiChat.client.CreateChatCompletion(context.Background(), request)
+		if err != nil {
+			return err
+		}
+		metadata.Latency = time.Since(start)
+		if len(response.Choices) == 0 {
+			return fmt.Errorf("no choices returned")
+		}
+		assistantResponse = response.Choices[0].Message.Content
+		metadata.Model = cmp.Or(response.Model, metadata.Model)
+		metadata.FinishReason = string(response.Choices[0].FinishReason)
+		if response.Usage.TotalTokens > 0 {
+			usage = &response.Usage
+		}
+		if _, err := fmt.Fprintln(out, assistantResponse); err != nil {
+			return err
+		}
+	} else {
+		var responseBuilder strings.Builder
+
+		writer := io.MultiWriter(out, &responseBuilder)
+
+		if !aiChat.noStreamUsage {
+			request.StreamOptions = &gogpt.StreamOptions{IncludeUsage: true}
+		}
+		result, err := streamCompletion(aiChat.client, request, writer, aiChat.options.verbose)
+		if err != nil && request.StreamOptions != nil && rejectedStreamOptions(err) {
+			// some OpenAI-compatible servers reject stream_options; the
+			// usage of their replies is estimated instead
+			if aiChat.options.verbose {
+				log.Printf("Retrying without stream_options: %v", err)
+			}
+			aiChat.noStreamUsage = true
+			request.StreamOptions = nil
+			start = time.Now()
+			result, err = streamCompletion(aiChat.client, request, writer, aiChat.options.verbose)
+		}
+		if err != nil {
+			return err
+		}
+		metadata.Latency = time.Since(start)
+		if !result.FirstToken.IsZero() {
+			metadata.TimeToFirstToken = result.FirstToken.Sub(start)
+		}
+		metadata.Model = cmp.Or(result.Model, metadata.Model)
+		metadata.FinishReason = result.FinishReason
+		usage = result.Usage
+
+		assistantResponse = responseBuilder.String()
+	}
+
+	metadata.PromptTokens, metadata.CompletionTokens, metadata.EstimatedUsage = aiChat.recordUsage(metadata.Model, request.Messages, assistantResponse, usage)
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, assistantResponse)
+	aiChat.conversation.Message(aiChat.conversation.ActiveLeaf).Metadata = metadata
+
+	if conv := aiChat.conversation; conv.Untitled {
+		conv.Untitled = false
+		// a conversation started from a prompt keeps the prompt's name until
+		// a title is generated
+		if conv.Prompt == "" {
+			conv.Title = GetConversationTitle(conv.ToGPTMessages())
+		}
+		if aiChat.config.AutoTitle {
+			aiChat.startTitle()
+		}
+	}
+	aiChat.writeJournal()
+	return nil
+}
+
+// addUserMessage adds input, with the attached files, to the conversation as
+// the user's next message. The files named by @path mentions are attached too
+// when the input is a line the user typed.
+func (aiChat *AIChat) addUserMessage(input string, typed bool) error {
+	var mentions []string
+	if typed {
+		mentions = findMentions(input)
+	}
+	if aiChat.inputTemplate != nil {
+		input = aiChat.inputTemplate(input)
+	}
+	content, attached, err := aiChat.prepareMessage(input, mentions)
+	if err != nil {
+		return err
+	}
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, content)
+	aiChat.conversation.Message(aiChat.conversation.ActiveLeaf).Attachments = attached
+	// the template applies to the first message only, once it is sent
+	aiChat.inputTemplate = nil
+	return nil
+}
+
+// singleTurn sends one message, writes only the reply to out and saves the
+// conversation. It is used by --message and --continue in scripts.
+func (aiChat *AIChat) singleTurn(message string, out io.Writer) error {
+	if aiChat.conversation == nil {
+		aiChat.conversation = NewConversation("New Conversation", aiChat.options.model)
+		aiChat.recordSettings()
+	}
+	if err := aiChat.addUserMessage(message, false); err != nil {
+		return err
+	}
+	if err := aiChat.generateReply(aiChat.options.temperature, out); err != nil {
+		return err
+	}
+	aiChat.applyPendingTitle(true)
+	if err := aiChat.saveConversation(); err != nil {
+		return fmt.Errorf("saving conversation: %w", err)
+	}
+	if aiChat.options.verbose {
+		log.Printf("Conversation saved with ID: %s", aiChat.conversation.ID)
+	}
+	return nil
+}
+
+// saveConversation saves the conversation. When another session saved it in
+// the meantime, the conversation can be saved as a copy with a new ID
+// instead, so that neither session loses its messages. An interactive
+// session asks first and returns errSaveConflict when the user declines;
+// a single turn always saves the copy.
+func (aiChat *AIChat) saveConversation() error {
+	conv := aiChat.conversation
+	err := SaveConversation(conv)
+	if !errors.Is(err, errSaveConflict) {
+		return err
+	}
+	if aiChat.reader != nil {
+		fmt.Println("Another session saved this conversation after it was loaded.")
+		saveCopy, err := aiChat.confirm("Save this session as a copy with a new ID?")
+		if err != nil {
+			return err
+		}
+		if !saveCopy {
+			return errSaveConflict
+		}
+	}
+	id, revision, savedAt := conv.ID, conv.Revision, conv.savedAt
+	conv.ID, conv.Revision, conv.savedAt = uuid.New().String(), 0, time.Time{}
+	if err := SaveConversation(conv); err != nil {
+		conv.ID, conv.Revision, conv.savedAt = id, revision, savedAt
+		return err
+	}
+	log.Printf("Conversation %s was saved by another session in the meantime; saved this one as a copy with ID: %s", id, conv.ID)
+	return nil
+}
+
+// retry regenerates the last assistant reply, optionally with another
+// temperature. The previous reply is kept as an alternative branch.
+func (aiChat *AIChat) retry(args string) error {
+	temperature := aiChat.options.temperature
+	if args != "" {
+		t, err := strconv.ParseFloat(args, 32)
+		if err != nil || t < 0 || t > 2 {
+			return fmt.Errorf("temperature must be a number between 0 and 2: %q", args)
+		}
+		temperature = float32(t)
+	}
+
+	conv := aiChat.conversation
+	path := conv.Path()
+	if n := len(path); n > 0 && path[n-1].Role == gogpt.ChatMessageRoleAssistant {
+		path = path[:n-1]
+	}
+	if n := len(path); n == 0 || path[n-1].Role != gogpt.ChatMessageRoleUser {
+		return fmt.Errorf("no reply to retry")
+	}
+	leaf := conv.ActiveLeaf
+	conv.Rewind(len(path))
+	if err := aiChat.reply(temperature); err != nil {
+		// without a new reply, the old one stays
+		conv.ActiveLeaf = leaf
+		return err
+	}
+	return nil
+}
+
+// undo drops the last exchange, the last user message and the replies to it,
+// from the active branch.
+func (aiChat *AIChat) undo() error {
+	conv := aiChat.conversation
+	i := conv.LastIndexOfRole(gogpt.ChatMessageRoleUser)
+	if i < 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	conv.Rewind(i)
+	return nil
+}
+
+// editMessage replaces the Nth message of the active branch, which must be
+// from the user, and regenerates the conversation from that point. The
+// original message and its replies are kept as an alternative branch.
+func (aiChat *AIChat) editMessage(args string) error {
+	numStr, content, _ := strings.Cut(args, " ")
+	content = strings.TrimSpace(content)
+	n, err := strconv.Atoi(numStr)
+	if err != nil || content == "" {
+		return fmt.Errorf("usage: /edit <number> <message>")
+	}
+
+	conv := aiChat.conversation
+	path := conv.Path()
+	if n < 1 || n > len(path) {
+		return fmt.Errorf("no message %d, see /history", n)
+	}
+	if path[n-1].Role != gogpt.ChatMessageRoleUser {
+		return fmt.Errorf("message %d is not a user message", n)
+	}
+	leaf := conv.ActiveLeaf
+	conv.Rewind(n - 1)
+	conv.AddMessage(gogpt.ChatMessageRoleUser, content)
+	if err := aiChat.reply(aiChat.options.temperature); err != nil {
+		// without a reply, the branch stays as it was; the edit is kept as an
+		// alternative so that its journaled ID is never given to another message
+		conv.ActiveLeaf = leaf
+		return err
+	}
+	return nil
+}
+
+// printHistory lists the messages of the active branch with their numbers.
+func (aiChat *AIChat) printHistory() {
+	path := aiChat.conversation.Path()
+	if len(path) == 0 {
+		fmt.Println("No messages.")
+		return
+	}
+	for i, msg := range path {
+		fmt.Printf("%3d %-9s %s\n", i+1, msg.Role+":", summarizeContent(msg.Content, 60))
+	}
+}
+
+// printMessageInfo shows how the Nth message of the active branch was
+// generated, by default the last reply.
+func (aiChat *AIChat) printMessageInfo(args string, w io.Writer) error {
+	path := aiChat.conversation.Path()
+	n := aiChat.conversation.LastIndexOfRole(gogpt.ChatMessageRoleAssistant) + 1
+	if args != "" {
+		var err error
+		if n, err = strconv.Atoi(args); err != nil {
+			return fmt.Errorf("usage: /info [number]")
+		}
+	}
+	if n < 1 || n > len(path) {
+		return fmt.Errorf("no message %d, see /history", n)
+	}
+	msg := path[n-1]
+	fmt.Fprintf(w, "Message %d: %s, %s\n", n, msg.Role, msg.Time.Local().Format("2006-01-02 15:04:05"))
+	for _, attachment := range msg.Attachments {
+		fmt.Fprintf(w, "  %-20s %s\n", "attachment:", attachment)
+	}
+	meta := msg.Metadata
+	if meta == nil {
+		if msg.Role == gogpt.ChatMessageRoleAssistant {
+			fmt.Fprintln(w, "No metadata was recorded for this reply.")
+		}
+		return nil
+	}
+	maxTokens := "default"
+	if meta.MaxTokens > 0 {
+		maxTokens = strconv.Itoa(meta.MaxTokens)
+	}
+	estimated := ""
+	if meta.EstimatedUsage {
+		estimated = " (estimated)"
+	}
+	fmt.Fprintf(w, "  %-20s %s\n", "model:", meta.Model)
+	fmt.Fprintf(w, "  %-20s %g\n", "temperature:", meta.Temperature)
+	fmt.Fprintf(w, "  %-20s %s\n", "max tokens:", maxTokens)
+	fmt.Fprintf(w, "  %-20s %d%s\n", "prompt tokens:", meta.PromptTokens, estimated)
+	fmt.Fprintf(w, "  %-20s %d%s\n", "completion tokens:", meta.CompletionTokens, estimated)
+	if price, ok := priceOfModel(meta.Model, aiChat.config.Prices); ok {
+		cost := (float64(meta.PromptTokens)*price.Input + float64(meta.CompletionTokens)*price.Output) / 1e6
+		fmt.Fprintf(w, "  %-20s $%.4f\n", "cost:", cost)
+	}
+	if meta.TimeToFirstToken > 0 {
+		fmt.Fprintf(w, "  %-20s %s\n", "time to first token:", meta.TimeToFirstToken.Round(time.Millisecond))
+	}
+	fmt.Fprintf(w, "  %-20s %s\n", "latency:", meta.Latency.Round(time.Millisecond))
+	fmt.Fprintf(w, "  %-20s %s\n", "finish reason:", cmp.Or(meta.FinishReason, "unknown"))
+	fmt.Fprintf(w, "  %-20s %t\n", "streamed:", meta.Streamed)
+	return nil
+}
+
+// printBranches shows, for each message of the active branch that has
+// alternatives, the alternatives with the IDs to pass to /switch.
+func (aiChat *AIChat) printBranches() {
+	conv := aiChat.conversation
+	found := false
+	for i, msg := range conv.Path() {
+		siblings := conv.Children(msg.ParentID)
+		if len(siblings) < 2 {
+			continue
+		}
+		found = true
+		fmt.Printf("Message %d has %d alternatives:\n", i+1, len(siblings))
+		for j, sibling := range siblings {
+			marker, branch := "├─", " "
+			if j == len(siblings)-1 {
+				marker = "└─"
+			}
+			if sibling.ID == msg.ID {
+				branch = "*"
+			}
+			fmt.Printf("  %s%s [%s] %-9s %s\n", marker, branch, sibling.ID, sibling.Role+":",
+				summarizeContent(sibling.Content, 50))
+		}
+	}
+	if !found {
+		fmt.Println("No alternative branches.")
+	}
+}
+
+// summarizeContent returns the first line of content, cut to at most width runes.
+func summarizeContent(content string, width int) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(content), "\n")
+	runes := []rune(line)
+	if len(runes) > width {
+		return string(runes[:width-3]) + "..."
+	}
+	return line
+}
+
+// runPrompt runs a prompt template on input outside of the conversation,
+// prints the result and returns it.
+func (aiChat *AIChat) runPrompt(prompt *Prompt, input string) (string, error) {
+	messages := prompt.CreateMessages(input)
+	maxTokens := firstNonZeroInt(aiChat.options.maxTokens, prompt.MaxTokens)
+	count, err := CountTokens(mapSlice(messages, func(m gogpt.ChatCompletionMessage) string { return m.Content }))
+	if err != nil {
+		return "", err
+	}
+	if limit := tokenLimitOfModel(aiChat.options.model); count+maxTokens > limit {
+		return "", fmt.Errorf("the prompt has %d tokens, which exceeds the limit of %d for %s",
+			count, limit, aiChat.options.model)
+	}
+	request := gogpt.ChatCompletionRequest{
+		Model:       aiChat.options.model,
+		Messages:    messages,
+		Temperature: firstNonZeroFloat32(prompt.Temperature, aiChat.options.temperature),
+		MaxTokens:   maxTokens,
+	}
+	var output strings.Builder
+	if err := aiChat.promptCompletion(request, io.MultiWriter(os.Stdout, &output)); err != nil {
+		return "", err
+	}
+	aiChat.recordUsage(request.Model, messages, output.String(), nil)
+	return output.String(), nil
+}
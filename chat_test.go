@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// newTestAIChat returns an AIChat talking to a fake API server that answers
+// every chat completion request with the given replies in turn.
+func newTestAIChat(t *testing.T, replies ...string) (*AIChat, *[]gogpt.ChatCompletionRequest) {
+	var requests []gogpt.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request gogpt.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		requests = append(requests, request)
+		reply := ""
+		if len(replies) > 0 {
+			reply, replies = replies[0], replies[1:]
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(gogpt.ChatCompletionResponse{
+			Choices: []gogpt.ChatCompletionChoice{{
+				Message: gogpt.ChatCompletionMessage{Role: gogpt.ChatMessageRoleAssistant, Content: reply},
+			}},
+		}); err != nil {
+			t.Errorf("Failed to encode response: %v", err)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	journalDir := t.TempDir()
+	origGetJournalDir := GetJournalDir
+	t.Cleanup(func() { GetJournalDir = origGetJournalDir })
+	GetJournalDir = func() (string, error) {
+		return journalDir, nil
+	}
+
+	config := gogpt.DefaultConfig("test")
+	config.BaseURL = server.URL
+	options := chatOptions{model: "gpt-4", temperature: 0.5, nonStreaming: true}
+	return &AIChat{
+		client:       gogpt.NewClientWithConfig(config),
+		options:      options,
+		defaults:     options,
+		conversation: NewConversation("New Conversation", "gpt-4"),
+	}, &requests
+}
+
+// failingClient returns a client whose requests all fail.
+func failingClient(t *testing.T) *gogpt.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"error": {"message": "server error"}}`)
+	}))
+	t.Cleanup(server.Close)
+	config := gogpt.DefaultConfig("test")
+	config.BaseURL = server.URL
+	return gogpt.NewClientWithConfig(config)
+}
+
+func messageContents(conv *Conversation) []string {
+	return mapSlice(conv.Path(), func(m ChatMessage) string { return m.Content })
+}
+
+func TestReply(t *testing.T) {
+	aiChat, requests := newTestAIChat(t, "Hi there!")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+
+	if err := aiChat.reply(0.5); err != nil {
+		t.Fatalf("reply() returned an error: %v", err)
+	}
+	if got := messageContents(aiChat.conversation); len(got) != 2 || got[1] != "Hi there!" {
+		t.Errorf("Expected the reply to be appended, got %q", got)
+	}
+	if aiChat.conversation.Title != "Hello" {
+		t.Errorf("Expected the title to be set from the first message, got %q", aiChat.conversation.Title)
+	}
+	if len(*requests) != 1 || len((*requests)[0].Messages) != 1 {
+		t.Errorf("Expected one request with the user message, got %+v", *requests)
+	}
+}
+
+func TestReplyMetadata(t *testing.T) {
+	aiChat, _ := newTestAIChat(t, "Hi there!")
+	aiChat.options.maxTokens = 100
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := aiChat.reply(0.5); err != nil {
+		t.Fatalf("reply() returned an error: %v", err)
+	}
+	meta := aiChat.conversation.Path()[1].Metadata
+	if meta == nil || meta.Model != "gpt-4" || meta.MaxTokens != 100 || meta.Streamed || !meta.EstimatedUsage || meta.CompletionTokens == 0 || meta.Latency <= 0 {
+		t.Errorf("Expected the reply's metadata to be recorded, got %+v", meta)
+	}
+
+	var info strings.Builder
+	if err := aiChat.printMessageInfo("", &info); err != nil {
+		t.Fatalf("printMessageInfo returned an error: %v", err)
+	}
+	for _, want := range []string{"Message 2: assistant", "gpt-4", "max tokens:          100", "(estimated)", "$"} {
+		if !strings.Contains(info.String(), want) {
+			t.Errorf("Expected the info to contain %q, got:\n%s", want, info.String())
+		}
+	}
+	info.Reset()
+	if err := aiChat.printMessageInfo("1", &info); err != nil || strings.Contains(info.String(), "model:") {
+		t.Errorf("Expected no metadata for the user message, got %v:\n%s", err, info.String())
+	}
+	for _, args := range []string{"3", "0", "x"} {
+		if err := aiChat.printMessageInfo(args, &info); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+
+	// the metadata is saved with the conversation
+	data, err := encodeConversation(aiChat.conversation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeConversation(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := decoded.Path()[1].Metadata; got == nil || *got != *meta {
+		t.Errorf("Expected the metadata to be saved, got %+v", got)
+	}
+}
+
+// streamTestServer serves every request as a stream of the chunks. With
+// rejectUsage, it refuses requests with stream_options as some
+// OpenAI-compatible servers do.
+func streamTestServer(t *testing.T, rejectUsage bool, chunks ...string) (*gogpt.Client, *[]gogpt.ChatCompletionRequest) {
+	var requests []gogpt.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request gogpt.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("Failed to decode request: %v", err)
+		}
+		requests = append(requests, request)
+		if rejectUsage && request.StreamOptions != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error": {"message": "Unrecognized request argument supplied: stream_options"}}`)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	config := gogpt.DefaultConfig("test")
+	config.BaseURL = server.URL
+	return gogpt.NewClientWithConfig(config), &requests
+}
+
+func TestStreamedReplyMetadata(t *testing.T) {
+	aiChat, _ := newTestAIChat(t)
+	aiChat.client, _ = streamTestServer(t, false,
+		`{"model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"}}]}`,
+		`{"model":"gpt-4o","choices":[{"index":0,"delta":{"content":" there"},"finish_reason":"stop"}]}`,
+		`{"model":"gpt-4o","choices":[],"usage":{"prompt_tokens":12,"completion_tokens":3,"total_tokens":15}}`,
+	)
+	aiChat.options.nonStreaming = false
+
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := aiChat.generateReply(0.5, io.Discard); err != nil {
+		t.Fatalf("generateReply() returned an error: %v", err)
+	}
+	meta := aiChat.conversation.Path()[1].Metadata
+	want := MessageMetadata{Model: "gpt-4o", Temperature: 0.5, Streamed: true, PromptTokens: 12, CompletionTokens: 3, FinishReason: "stop"}
+	if meta == nil || meta.TimeToFirstToken <= 0 || meta.Latency < meta.TimeToFirstToken {
+		t.Fatalf("Expected the timings to be recorded, got %+v", meta)
+	}
+	meta.TimeToFirstToken, meta.Latency = 0, 0
+	if *meta != want {
+		t.Errorf("Expected %+v, got %+v", want, *meta)
+	}
+	// priced as the model that answered, not the gpt-4 that was asked for
+	if cost := (12*2.5 + 3*10) / 1e6; aiChat.usage.cost != cost {
+		t.Errorf("Expected a cost of %v, got %v", cost, aiChat.usage.cost)
+	}
+}
+
+func TestStreamedReplyWithoutUsage(t *testing.T) {
+	aiChat, _ := newTestAIChat(t)
+	client, requests := streamTestServer(t, true,
+		`{"choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`,
+	)
+	aiChat.client = client
+	aiChat.options.nonStreaming = false
+
+	for _, message := range []string{"Hello", "Again"} {
+		aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, message)
+		if err := aiChat.generateReply(0.5, io.Discard); err != nil {
+			t.Fatalf("generateReply() returned an error: %v", err)
+		}
+	}
+	if got := messageContents(aiChat.conversation); len(got) != 4 || strings.TrimSpace(got[3]) != "Hi" {
+		t.Errorf("Expected both replies, got %q", got)
+	}
+	if meta := aiChat.conversation.Path()[3].Metadata; meta == nil || !meta.EstimatedUsage {
+		t.Errorf("Expected the usage to be estimated, got %+v", meta)
+	}
+	// the first reply is retried without stream_options, the second doesn't send them
+	if len(*requests) != 3 || (*requests)[2].StreamOptions != nil {
+		t.Errorf("Expected stream_options to be dropped after the rejection, got %d requests", len(*requests))
+	}
+}
+
+func TestStreamedReplyInvalidRequest(t *testing.T) {
+	aiChat, _ := newTestAIChat(t)
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error": {"message": "This model's maximum context length is 8192 tokens."}}`)
+	}))
+	t.Cleanup(server.Close)
+	config := gogpt.DefaultConfig("test")
+	config.BaseURL = server.URL
+	aiChat.client = gogpt.NewClientWithConfig(config)
+	aiChat.options.nonStreaming = false
+
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := aiChat.generateReply(0.5, io.Discard); err == nil {
+		t.Error("Expected the invalid request to fail")
+	}
+	if requests != 1 || aiChat.noStreamUsage {
+		t.Errorf("Expected no retry without stream_options, got %d requests", requests)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	aiChat, requests := newTestAIChat(t, "second")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, "first")
+
+	if err := aiChat.retry("1.2"); err != nil {
+		t.Fatalf("retry() returned an error: %v", err)
+	}
+	if got := messageContents(aiChat.conversation); len(got) != 2 || got[1] != "second" {
+		t.Errorf("Expected the reply to be replaced, got %q", got)
+	}
+	if len(aiChat.conversation.Children("1")) != 2 {
+		t.Errorf("Expected the old reply to be kept as an alternative, got %+v", aiChat.conversation.Messages)
+	}
+	if (*requests)[0].Temperature != 1.2 {
+		t.Errorf("Expected temperature 1.2, got %v", (*requests)[0].Temperature)
+	}
+	if len((*requests)[0].Messages) != 1 {
+		t.Errorf("Expected the old reply not to be sent, got %+v", (*requests)[0].Messages)
+	}
+
+	aiChat.client = failingClient(t)
+	if err := aiChat.retry(""); err == nil {
+		t.Error("Expected retry to fail when the API call fails")
+	}
+	if got := messageContents(aiChat.conversation); len(got) != 2 || got[1] != "second" {
+		t.Errorf("Expected a failed retry to keep the reply, got %q", got)
+	}
+
+	if err := aiChat.retry("hot"); err == nil {
+		t.Error("Expected an invalid temperature to fail")
+	}
+	aiChat.conversation = NewConversation("Empty", "gpt-4")
+	if err := aiChat.retry(""); err == nil {
+		t.Error("Expected retry on an empty conversation to fail")
+	}
+}
+
+func TestUndo(t *testing.T) {
+	aiChat, _ := newTestAIChat(t)
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleSystem, "system")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "one")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, "reply one")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "two")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, "reply two")
+
+	if err := aiChat.undo(); err != nil {
+		t.Fatalf("undo() returned an error: %v", err)
+	}
+	if got := messageContents(aiChat.conversation); len(got) != 3 || got[2] != "reply one" {
+		t.Errorf("Expected the last exchange to be removed, got %q", got)
+	}
+	if err := aiChat.undo(); err != nil {
+		t.Fatalf("undo() returned an error: %v", err)
+	}
+	if err := aiChat.undo(); err == nil {
+		t.Error("Expected undo without user messages to fail")
+	}
+	if got := messageContents(aiChat.conversation); len(got) != 1 {
+		t.Errorf("Expected only the system message to remain, got %q", got)
+	}
+}
+
+func TestEditMessage(t *testing.T) {
+	aiChat, requests := newTestAIChat(t, "new reply")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "one")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, "reply one")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "two")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, "reply two")
+
+	for _, invalid := range []string{"", "x hello", "1", "2 hello", "9 hello"} {
+		if err := aiChat.editMessage(invalid); err == nil {
+			t.Errorf("Expected editMessage(%q) to fail", invalid)
+		}
+	}
+	if len(*requests) != 0 {
+		t.Fatalf("Expected no requests for invalid edits, got %d", len(*requests))
+	}
+
+	if err := aiChat.editMessage("1 uno"); err != nil {
+		t.Fatalf("editMessage() returned an error: %v", err)
+	}
+	if got := messageContents(aiChat.conversation); len(got) != 2 || got[0] != "uno" || got[1] != "new reply" {
+		t.Errorf("Expected the conversation to be rewritten from message 1, got %q", got)
+	}
+	if len(aiChat.conversation.Children("")) != 2 {
+		t.Errorf("Expected the original message to be kept as an alternative, got %+v", aiChat.conversation.Messages)
+	}
+
+	aiChat.client = failingClient(t)
+	if err := aiChat.editMessage("1 eins"); err == nil {
+		t.Error("Expected the edit to fail when the API call fails")
+	}
+	if got := messageContents(aiChat.conversation); len(got) != 2 || got[0] != "uno" || got[1] != "new reply" {
+		t.Errorf("Expected a failed edit to keep the branch, got %q", got)
+	}
+	if len(aiChat.conversation.Messages) != 7 || aiChat.conversation.Messages[6].Content != "eins" {
+		t.Errorf("Expected a failed edit to be kept as an alternative, got %+v", aiChat.conversation.Messages)
+	}
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "next")
+	if id := aiChat.conversation.ActiveLeaf; id == "7" {
+		t.Errorf("Expected the next message not to reuse the failed edit's ID, got %s", id)
+	}
+}
+
+func TestSummarizeContent(t *testing.T) {
+	if got := summarizeContent("short\nsecond line", 10); got != "short" {
+		t.Errorf("Expected the first line, got %q", got)
+	}
+	if got := summarizeContent("こんにちは世界、元気ですか", 8); got != "こんにちは..." {
+		t.Errorf("Expected rune-safe truncation, got %q", got)
+	}
+}
+
+func TestSingleTurn(t *testing.T) {
+	useTestHistory(t)
+
+	aiChat, requests := newTestAIChat(t, "4")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Let's do arithmetic.")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, "Sure.")
+
+	var out strings.Builder
+	if err := aiChat.singleTurn("2+2?", &out); err != nil {
+		t.Fatalf("singleTurn() returned an error: %v", err)
+	}
+	if out.String() != "4\n" {
+		t.Errorf("Expected only the reply to be printed, got %q", out.String())
+	}
+	if len(*requests) != 1 || len((*requests)[0].Messages) != 3 {
+		t.Errorf("Expected the history to be sent with the message, got %+v", *requests)
+	}
+	saved, err := LoadConversation(aiChat.conversation.ID)
+	if err != nil {
+		t.Fatalf("Expected the conversation to be saved: %v", err)
+	}
+	if got := messageContents(saved); len(got) != 4 || got[2] != "2+2?" || got[3] != "4" {
+		t.Errorf("Unexpected saved messages: %q", got)
+	}
+}
+
+func TestSaveConversationCopyOnConflict(t *testing.T) {
+	useTestHistory(t)
+
+	conv := NewConversation("Shared", "gpt-4")
+	conv.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := SaveConversation(conv); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	other, _ := LoadConversation(conv.ID)
+	other.AddMessage(gogpt.ChatMessageRoleUser, "From another session")
+	if err := SaveConversation(other); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+
+	aiChat := &AIChat{conversation: conv}
+	conv.AddMessage(gogpt.ChatMessageRoleUser, "From this session")
+	if err := aiChat.saveConversation(); err != nil {
+		t.Fatalf("saveConversation failed: %v", err)
+	}
+	if aiChat.conversation.ID == other.ID {
+		t.Fatal("Expected the conversation to be saved as a copy")
+	}
+	conversations, _ := ListConversations()
+	if len(conversations) != 2 {
+		t.Errorf("Expected both sessions to be saved, got %d conversations", len(conversations))
+	}
+
+	// an interactive session asks before saving a copy
+	other, _ = LoadConversation(aiChat.conversation.ID)
+	other.AddMessage(gogpt.ChatMessageRoleUser, "Again from another session")
+	if err := SaveConversation(other); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	aiChat.reader = newScannerLineReader(strings.NewReader("n\n"), io.Discard)
+	if err := aiChat.saveConversation(); !errors.Is(err, errSaveConflict) || aiChat.conversation.ID != other.ID {
+		t.Errorf("Expected declining to keep the ID and report the conflict, got %v", err)
+	}
+	aiChat.reader = newScannerLineReader(strings.NewReader("y\n"), io.Discard)
+	if err := aiChat.saveConversation(); err != nil || aiChat.conversation.ID == other.ID {
+		t.Errorf("Expected accepting to save a copy, got %v", err)
+	}
+}
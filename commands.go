@@ -0,0 +1,509 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// errExit is returned by a command handler to leave the chat loop.
+var errExit = errors.New("exit")
+
+// chatCommand is a slash command of the chat loop.
+type chatCommand struct {
+	name string
+	// args describes the arguments for /help. Arguments in <> are required.
+	args string
+	help string
+	// run handles the command. A non-empty result is sent as the user's next message.
+	run func(aiChat *AIChat, args string) (string, error)
+}
+
+// commandRegistry holds the slash commands in the order they are listed by /help.
+type commandRegistry struct {
+	commands []*chatCommand
+	byName   map[string]*chatCommand
+}
+
+func (r *commandRegistry) register(command *chatCommand) error {
+	if r.byName == nil {
+		r.byName = map[string]*chatCommand{}
+	}
+	if _, ok := r.byName[command.name]; ok {
+		return fmt.Errorf("command /%s is already defined", command.name)
+	}
+	r.commands = append(r.commands, command)
+	r.byName[command.name] = command
+	return nil
+}
+
+// names returns the command names for completion.
+func (r *commandRegistry) names() []string {
+	return mapSlice(r.commands, func(c *chatCommand) string { return c.name })
+}
+
+// parseCommand splits "/name args" into the name and the arguments. Shell
+// commands may be written without a space, as in "/!ls".
+func parseCommand(input string) (string, string) {
+	line := strings.TrimSpace(strings.TrimPrefix(input, "/"))
+	for _, name := range []string{"!!", "!"} {
+		if strings.HasPrefix(line, name) {
+			return name, strings.TrimSpace(strings.TrimPrefix(line, name))
+		}
+	}
+	name, args, _ := strings.Cut(line, " ")
+	return name, strings.TrimSpace(args)
+}
+
+// run runs the slash command in input.
+func (r *commandRegistry) run(aiChat *AIChat, input string) (string, error) {
+	name, args := parseCommand(input)
+	command := r.byName[name]
+	if command == nil {
+		return "", fmt.Errorf("unknown command /%s, type /help for the list of commands", name)
+	}
+	if args == "" && strings.HasPrefix(command.args, "<") {
+		return "", fmt.Errorf("usage: /%s %s", command.name, command.args)
+	}
+	return command.run(aiChat, args)
+}
+
+// help returns the help text listing every command.
+func (r *commandRegistry) help() string {
+	usages := mapSlice(r.commands, func(c *chatCommand) string {
+		return strings.TrimSpace("/" + c.name + " " + c.args)
+	})
+	width := 0
+	for _, usage := range usages {
+		width = max(width, len(usage))
+	}
+	var help strings.Builder
+	help.WriteString("Available commands:\n")
+	for i, command := range r.commands {
+		fmt.Fprintf(&help, "  %-*s - %s\n", width, usages[i], command.help)
+	}
+	help.WriteString("\n")
+	help.WriteString(`Start a line with """ to write a multi-line message, and end it with """.` + "\n")
+	help.WriteString("Start a line with // to send a message beginning with /, such as a path.\n")
+	help.WriteString("Press Ctrl-X Ctrl-E to write the message in $EDITOR.\n")
+	return help.String()
+}
+
+// newCommandRegistry returns the built-in commands followed by the commands
+// defined in the config file.
+func newCommandRegistry(userCommands map[string]UserCommand) *commandRegistry {
+	r := &commandRegistry{}
+	for _, command := range builtinCommands() {
+		if err := r.register(command); err != nil {
+			panic(err)
+		}
+	}
+	for _, name := range sortedKeys(userCommands) {
+		if err := r.register(newUserCommand(name, userCommands[name])); err != nil {
+			log.Printf("WARN: ignoring user command: %v", err)
+		}
+	}
+	r.byName["help"].run = func(aiChat *AIChat, args string) (string, error) {
+		fmt.Print(r.help())
+		return "", nil
+	}
+	return r
+}
+
+func builtinCommands() []*chatCommand {
+	return []*chatCommand{
+		{name: "save", help: "Save the current conversation", run: func(aiChat *AIChat, args string) (string, error) {
+			if len(aiChat.conversation.Messages) == 0 {
+				fmt.Println("No messages to save.")
+				return "", nil
+			}
+			aiChat.applyPendingTitle(false)
+			if err := aiChat.saveConversation(); err != nil {
+				return "", fmt.Errorf("saving conversation: %w", err)
+			}
+			fmt.Printf("Conversation saved with ID: %s\n", aiChat.conversation.ID)
+			return "", nil
+		}},
+		{name: "list", args: "[tag:t status:s ...]", help: "List saved conversations (tag:, status:, model:, since:, until:, limit:, offset:)", run: func(aiChat *AIChat, args string) (string, error) {
+			query, err := parseListQuery(args)
+			if err != nil {
+				return "", err
+			}
+			if err := printHistory(os.Stdout, query, "table"); err != nil {
+				return "", fmt.Errorf("listing conversations: %w", err)
+			}
+			return "", nil
+		}},
+		{name: "load", args: "<id>", help: "Load a conversation by ID or title", run: func(aiChat *AIChat, args string) (string, error) {
+			id, err := FindConversationID(args, readerChooser(aiChat.reader))
+			if err != nil {
+				return "", err
+			}
+			conv, err := LoadConversation(id)
+			if err != nil {
+				return "", fmt.Errorf("loading conversation: %w", err)
+			}
+			aiChat.setConversation(conv)
+			fmt.Printf("Loaded conversation: %s\n", conv.Title)
+			return "", nil
+		}},
+		{name: "delete", args: "<id>", help: "Delete a conversation by ID, asking first when found by title", run: func(aiChat *AIChat, args string) (string, error) {
+			id, err := FindConversationToDelete(args, readerChooser(aiChat.reader), aiChat.confirm)
+			if err != nil {
+				return "", err
+			}
+			if err := DeleteConversation(id); err != nil {
+				return "", fmt.Errorf("deleting conversation: %w", err)
+			}
+			fmt.Println("Conversation deleted.")
+			return "", nil
+		}},
+		{name: "search", args: "<query>", help: "Search saved conversations (model:, since:, until: filter)", run: func(aiChat *AIChat, args string) (string, error) {
+			query, err := parseSearchQuery(args)
+			if err != nil {
+				return "", err
+			}
+			hits, err := SearchConversations(query)
+			if err != nil {
+				return "", err
+			}
+			printSearchHits(hits)
+			return "", nil
+		}},
+		{name: "export", args: "<md|json|html|jsonl> [file]", help: "Export the conversation to a file", run: func(aiChat *AIChat, args string) (string, error) {
+			format, path, _ := strings.Cut(args, " ")
+			path = strings.TrimSpace(path)
+			if path == "" {
+				path = aiChat.conversation.ID + exportFormats[format]
+			}
+			if err := exportFile(path, []*Conversation{aiChat.conversation}, format); err != nil {
+				return "", err
+			}
+			fmt.Printf("Exported to %s\n", path)
+			return "", nil
+		}},
+		{name: "prompt", args: "<name>", help: "Start a new conversation from a prompt template", run: func(aiChat *AIChat, args string) (string, error) {
+			prompt, err := findPrompt(args)
+			if err != nil {
+				return "", err
+			}
+			aiChat.startPrompt(args, prompt)
+			fmt.Printf("Started a new conversation with prompt: %s\n", args)
+			return "", nil
+		}},
+		{name: "run", args: "<prompt> [text|#n]", help: "Run a prompt on text, message n or the last reply", run: func(aiChat *AIChat, args string) (string, error) {
+			name, text, _ := strings.Cut(args, " ")
+			prompt, err := findPrompt(name)
+			if err != nil {
+				return "", err
+			}
+			input, err := aiChat.runInput(strings.TrimSpace(text))
+			if err != nil {
+				return "", err
+			}
+			output, err := aiChat.runPrompt(prompt, input)
+			if err != nil {
+				return "", err
+			}
+			add, err := aiChat.confirm("Add the result to the conversation?")
+			if err != nil || !add {
+				return "", err
+			}
+			aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, strings.TrimSpace(output))
+			fmt.Println("Added the result to the conversation.")
+			return "", nil
+		}},
+		{name: "edit-message", args: "[last]", help: "Write the next message in $EDITOR", run: func(aiChat *AIChat, args string) (string, error) {
+			initial := aiChat.config.EditorTemplate
+			if args == "last" {
+				if i := aiChat.conversation.LastIndexOfRole(gogpt.ChatMessageRoleUser); i >= 0 {
+					initial = aiChat.conversation.Path()[i].Content
+				}
+			}
+			return aiChat.composeMessage(initial), nil
+		}},
+		{name: "file", args: "<path>...", help: "Attach files, globs or directories to the next message", run: func(aiChat *AIChat, args string) (string, error) {
+			return "", aiChat.attachFiles(strings.Fields(args))
+		}},
+		{name: "detach", help: "Remove the files attached with /file", run: func(aiChat *AIChat, args string) (string, error) {
+			aiChat.attachments = nil
+			fmt.Println("Removed the attached files.")
+			return "", nil
+		}},
+		{name: "!", args: "<command>", help: "Run a shell command and optionally add its output", run: func(aiChat *AIChat, args string) (string, error) {
+			message, err := shellMessage(args)
+			if err != nil {
+				return "", err
+			}
+			add, err := aiChat.confirm("Add the output to the conversation?")
+			if err != nil || !add {
+				return "", err
+			}
+			aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, message)
+			fmt.Println("Added the output to the conversation.")
+			return "", nil
+		}},
+		{name: "!!", args: "<command>", help: "Run a shell command and send its output to the model", run: func(aiChat *AIChat, args string) (string, error) {
+			message, err := shellMessage(args)
+			if err != nil {
+				return "", err
+			}
+			aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, message)
+			return "", aiChat.reply(aiChat.options.temperature)
+		}},
+		{name: "title", args: "[title]", help: "Rename the conversation, or generate a title", run: func(aiChat *AIChat, args string) (string, error) {
+			return "", aiChat.renameConversation(args)
+		}},
+		{name: "tag", args: "[tag|-tag ...]", help: "Show the tags, or add tags and remove -tags", run: func(aiChat *AIChat, args string) (string, error) {
+			var add, remove []string
+			for _, tag := range strings.Fields(args) {
+				if name, ok := strings.CutPrefix(tag, "-"); ok {
+					remove = append(remove, name)
+				} else {
+					add = append(add, tag)
+				}
+			}
+			err := aiChat.updateConversation(func(conv *Conversation) {
+				conv.AddTags(add...)
+				conv.RemoveTags(remove...)
+			})
+			if err != nil {
+				return "", err
+			}
+			if len(aiChat.conversation.Tags) == 0 {
+				fmt.Println("No tags.")
+			} else {
+				fmt.Printf("Tags: %s\n", strings.Join(aiChat.conversation.Tags, ", "))
+			}
+			return "", nil
+		}},
+		{name: "pin", help: "Pin or unpin the conversation at the top of the list", run: func(aiChat *AIChat, args string) (string, error) {
+			pinned := !aiChat.conversation.Pinned
+			if err := aiChat.updateConversation(func(conv *Conversation) { conv.Pinned = pinned }); err != nil {
+				return "", err
+			}
+			if pinned {
+				fmt.Println("Conversation pinned.")
+			} else {
+				fmt.Println("Conversation unpinned.")
+			}
+			return "", nil
+		}},
+		{name: "archive", help: "Archive or unarchive the conversation, hiding it from the list", run: func(aiChat *AIChat, args string) (string, error) {
+			archived := !aiChat.conversation.Archived
+			if err := aiChat.updateConversation(func(conv *Conversation) { conv.Archived = archived }); err != nil {
+				return "", err
+			}
+			if archived {
+				fmt.Println("Conversation archived.")
+			} else {
+				fmt.Println("Conversation unarchived.")
+			}
+			return "", nil
+		}},
+		{name: "history", help: "Show the messages with their numbers", run: func(aiChat *AIChat, args string) (string, error) {
+			aiChat.printHistory()
+			return "", nil
+		}},
+		{name: "retry", args: "[t]", help: "Regenerate the last reply, optionally with temperature t", run: func(aiChat *AIChat, args string) (string, error) {
+			return "", aiChat.retry(args)
+		}},
+		{name: "undo", help: "Remove the last exchange", run: func(aiChat *AIChat, args string) (string, error) {
+			if err := aiChat.undo(); err != nil {
+				return "", err
+			}
+			fmt.Println("Removed the last exchange.")
+			return "", nil
+		}},
+		{name: "edit", args: "<n> <text>", help: "Rewrite user message n and regenerate from there", run: func(aiChat *AIChat, args string) (string, error) {
+			return "", aiChat.editMessage(args)
+		}},
+		{name: "branches", help: "Show alternatives kept by /retry and /edit", run: func(aiChat *AIChat, args string) (string, error) {
+			aiChat.printBranches()
+			return "", nil
+		}},
+		{name: "switch", args: "<id>", help: "Switch to the branch containing message <id>", run: func(aiChat *AIChat, args string) (string, error) {
+			if err := aiChat.conversation.Switch(args); err != nil {
+				return "", err
+			}
+			aiChat.printHistory()
+			return "", nil
+		}},
+		{name: "tokens", help: "Show the context usage and the session cost", run: func(aiChat *AIChat, args string) (string, error) {
+			return "", aiChat.printTokens()
+		}},
+		{name: "context", help: "Same as /tokens", run: func(aiChat *AIChat, args string) (string, error) {
+			return "", aiChat.printTokens()
+		}},
+		{name: "info", args: "[n]", help: "Show the model, tokens, latency and finish reason of message n, the last reply by default", run: func(aiChat *AIChat, args string) (string, error) {
+			return "", aiChat.printMessageInfo(args, os.Stdout)
+		}},
+		settingCommand("model", "<name>", "Change the model"),
+		settingCommand("temperature", "<t>", "Change the temperature"),
+		settingCommand("max-tokens", "<n>", "Change max tokens, 0 to use default"),
+		settingCommand("stream", "<on|off>", "Turn streaming on or off"),
+		{name: "settings", help: "Show the current settings", run: func(aiChat *AIChat, args string) (string, error) {
+			aiChat.printSettings()
+			return "", nil
+		}},
+		{name: "help", help: "Show this help message"},
+		{name: "exit", help: "Exit (or type Ctrl-D)", run: func(aiChat *AIChat, args string) (string, error) {
+			return "", errExit
+		}},
+	}
+}
+
+func settingCommand(name, args, help string) *chatCommand {
+	return &chatCommand{name: name, args: args, help: help, run: func(aiChat *AIChat, value string) (string, error) {
+		if err := aiChat.setSetting(name, value); err != nil {
+			return "", err
+		}
+		aiChat.printSettings()
+		return "", nil
+	}}
+}
+
+// UserCommand is a slash command defined in the config file. It either runs
+// a prompt template or sends a canned message; $INPUT in the message is
+// replaced with the command's argument.
+type UserCommand struct {
+	Description string `yaml:"description"`
+	// Prompt is the name of the prompt template to run.
+	Prompt string `yaml:"prompt"`
+	// Message is the message to send.
+	Message string `yaml:"message"`
+	// Input selects the input used when no argument is given:
+	// "last-reply" (the default) or "last-code-block".
+	Input string `yaml:"input"`
+}
+
+func newUserCommand(name string, uc UserCommand) *chatCommand {
+	help := uc.Description
+	if help == "" && uc.Prompt != "" {
+		help = fmt.Sprintf("Run the %s prompt", uc.Prompt)
+	}
+	if help == "" {
+		help = summarizeContent(uc.Message, 50)
+	}
+	return &chatCommand{name: name, args: "[text]", help: help, run: func(aiChat *AIChat, args string) (string, error) {
+		needsInput := uc.Prompt != "" || strings.Contains(uc.Message, DefaultInputMarker)
+		input := args
+		if input == "" && needsInput {
+			var err error
+			if input, err = aiChat.conversationInput(uc.Input); err != nil {
+				return "", err
+			}
+		}
+		if uc.Prompt != "" {
+			prompt, err := findPrompt(uc.Prompt)
+			if err != nil {
+				return "", err
+			}
+			_, err = aiChat.runPrompt(prompt, input)
+			return "", err
+		}
+		if uc.Message == "" {
+			return "", fmt.Errorf("command /%s has neither a prompt nor a message", name)
+		}
+		return strings.ReplaceAll(uc.Message, DefaultInputMarker, input), nil
+	}}
+}
+
+// conversationInput returns the part of the conversation selected by source
+// for use as a command's input.
+func (aiChat *AIChat) conversationInput(source string) (string, error) {
+	i := aiChat.conversation.LastIndexOfRole(gogpt.ChatMessageRoleAssistant)
+	if i < 0 {
+		return "", fmt.Errorf("no reply to use as input")
+	}
+	reply := aiChat.conversation.Path()[i].Content
+	switch source {
+	case "", "last-reply":
+		return reply, nil
+	case "last-code-block":
+		blocks := codeBlocks(reply)
+		if len(blocks) == 0 {
+			return "", fmt.Errorf("no code block in the last reply")
+		}
+		return blocks[len(blocks)-1], nil
+	default:
+		return "", fmt.Errorf("unknown input %q, use last-reply or last-code-block", source)
+	}
+}
+
+// runInput returns the input of /run: the text itself, the content of message
+// n of the active branch for "#n", or the last reply when text is empty.
+func (aiChat *AIChat) runInput(text string) (string, error) {
+	if text == "" {
+		return aiChat.conversationInput("last-reply")
+	}
+	numStr, ok := strings.CutPrefix(text, "#")
+	if !ok {
+		return text, nil
+	}
+	n, err := strconv.Atoi(numStr)
+	if err != nil {
+		return text, nil
+	}
+	path := aiChat.conversation.Path()
+	if n < 1 || n > len(path) {
+		return "", fmt.Errorf("no message %d, see /history", n)
+	}
+	return path[n-1].Content, nil
+}
+
+// confirm asks a yes/no question, defaulting to no.
+func (aiChat *AIChat) confirm(question string) (bool, error) {
+	return readerConfirm(aiChat.reader)(question)
+}
+
+// codeBlocks returns the contents of the fenced code blocks in a Markdown text.
+func codeBlocks(text string) []string {
+	var blocks []string
+	var fence string
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence == "" {
+			if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+				fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+				lines = nil
+			}
+			continue
+		}
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			blocks = append(blocks, strings.Join(lines, "\n"))
+			fence = ""
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return blocks
+}
+
+func findPrompt(name string) (*Prompt, error) {
+	prompts, err := ReadPrompts()
+	if err != nil {
+		return nil, fmt.Errorf("reading prompts: %w", err)
+	}
+	prompt := prompts[name]
+	if prompt == nil {
+		return nil, fmt.Errorf("prompt %q not found", name)
+	}
+	return prompt, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		input, name, args string
+	}{
+		{"/save", "save", ""},
+		{"/load  abc ", "load", "abc"},
+		{"/edit 3 new text", "edit", "3 new text"},
+		{"/!ls -l", "!", "ls -l"},
+		{"/!! go test ./...", "!!", "go test ./..."},
+	}
+	for _, test := range tests {
+		name, args := parseCommand(test.input)
+		if name != test.name || args != test.args {
+			t.Errorf("%q: expected (%q, %q), got (%q, %q)", test.input, test.name, test.args, name, args)
+		}
+	}
+}
+
+func TestCommandRegistry(t *testing.T) {
+	r := newCommandRegistry(map[string]UserCommand{
+		"save": {Message: "clashes with a builtin"},
+		"tldr": {Message: "Summarize this in one line:\n$INPUT"},
+		"more": {Message: "Please continue."},
+		"fix":  {Message: "Fix this code:\n$INPUT", Input: "last-code-block"},
+	})
+	aiChat, requests := newTestAIChat(t)
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Write hello world")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, "Here:\n\n```go\nfmt.Println(\"hi\")\n```\n\nDone.")
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"/tldr some text", "Summarize this in one line:\nsome text"},
+		{"/more", "Please continue."},
+		{"/fix", "Fix this code:\nfmt.Println(\"hi\")"},
+		{"/settings", ""},
+	}
+	for _, test := range tests {
+		message, err := r.run(aiChat, test.input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.input, err)
+		}
+		if message != test.expected {
+			t.Errorf("%q: expected message %q, got %q", test.input, test.expected, message)
+		}
+	}
+	if len(*requests) != 0 {
+		t.Errorf("Expected commands that return a message not to call the API, got %d requests", len(*requests))
+	}
+
+	if message, err := r.run(aiChat, "/tldr"); err != nil || !strings.HasSuffix(message, "Done.") {
+		t.Errorf("Expected /tldr without text to use the last reply, got %q, %v", message, err)
+	}
+	if _, err := r.run(aiChat, "/exit"); !errors.Is(err, errExit) {
+		t.Errorf("Expected /exit to return errExit, got %v", err)
+	}
+	if _, err := r.run(aiChat, "/nope"); err == nil || !strings.Contains(err.Error(), "unknown command /nope") {
+		t.Errorf("Expected an unknown command error, got %v", err)
+	}
+	if _, err := r.run(aiChat, "/load"); err == nil || err.Error() != "usage: /load <id>" {
+		t.Errorf("Expected a usage error, got %v", err)
+	}
+	if r.byName["save"].help != "Save the current conversation" {
+		t.Errorf("Expected a user command not to replace a builtin")
+	}
+	if help := r.help(); !strings.Contains(help, "/tldr [text]") || !strings.Contains(help, "/!! <command>") {
+		t.Errorf("Expected the help to list every command, got:\n%s", help)
+	}
+}
+
+func TestPromptCommand(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeTestFiles(t, filepath.Join(home, ".aichat", "prompts"), map[string]string{
+		"review.yml": "messages:\n  - role: system\n    content: Review the code.\n  - role: user\n    content: $INPUT\n",
+	})
+	r := newCommandRegistry(map[string]UserCommand{
+		"review": {Prompt: "review", Input: "last-code-block"},
+	})
+	aiChat, requests := newTestAIChat(t, "Looks good.")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, "```\nx := 1\n```")
+
+	message, err := r.run(aiChat, "/review")
+	if err != nil {
+		t.Fatalf("/review returned an error: %v", err)
+	}
+	if message != "" {
+		t.Errorf("Expected a prompt command not to send a message, got %q", message)
+	}
+	if len(*requests) != 1 || (*requests)[0].Messages[1].Content != "x := 1" {
+		t.Errorf("Expected the prompt to run on the code block, got %+v", *requests)
+	}
+	if len(aiChat.conversation.Messages) != 1 {
+		t.Errorf("Expected the conversation to be unchanged, got %+v", aiChat.conversation.Messages)
+	}
+}
+
+func TestCodeBlocks(t *testing.T) {
+	text := "a\n```go\none\n```\nb\n~~~~\ntwo\n```\nstill two\n~~~~\n"
+	blocks := codeBlocks(text)
+	if len(blocks) != 2 || blocks[0] != "one" || blocks[1] != "two\n```\nstill two" {
+		t.Errorf("Unexpected code blocks: %q", blocks)
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	writeTestFiles(t, filepath.Join(home, ".aichat", "prompts"), map[string]string{
+		"translate.yml": "messages:\n  - role: user\n    content: \"Translate into Japanese: $INPUT\"\n",
+	})
+	r := newCommandRegistry(nil)
+	aiChat, requests := newTestAIChat(t, "こんにちは", "さようなら", "ありがとう")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Say goodbye")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleAssistant, "Goodbye")
+
+	tests := []struct {
+		args, answer, input string
+		added               bool
+	}{
+		{"translate Hello", "n\n", "Hello", false},
+		{"translate", "y\n", "Goodbye", true},
+		{"translate #1", "", "Say goodbye", false},
+	}
+	for i, test := range tests {
+		aiChat.reader = newScannerLineReader(strings.NewReader(test.answer), io.Discard)
+		before := len(aiChat.conversation.Path())
+		if _, err := r.run(aiChat, "/run "+test.args); err != nil {
+			t.Fatalf("/run %s returned an error: %v", test.args, err)
+		}
+		if got := (*requests)[i].Messages[0].Content; got != "Translate into Japanese: "+test.input {
+			t.Errorf("/run %s: unexpected request %q", test.args, got)
+		}
+		if added := len(aiChat.conversation.Path()) > before; added != test.added {
+			t.Errorf("/run %s: expected added=%v, got %v", test.args, test.added, added)
+		}
+	}
+	if got := messageContents(aiChat.conversation); got[len(got)-1] != "さようなら" {
+		t.Errorf("Expected the result to be added as a reply, got %q", got)
+	}
+
+	if _, err := r.run(aiChat, "/run translate #9"); err == nil {
+		t.Errorf("Expected an error for a missing message")
+	}
+	if _, err := r.run(aiChat, "/run missing"); err == nil {
+		t.Errorf("Expected an error for a missing prompt")
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// completer completes slash command names and their arguments, and @path
+// mentions, at the chat prompt.
+type completer struct {
+	commands          []string
+	listConversations func() ([]ConversationInfo, error)
+	readPrompts       func() (map[string]*Prompt, error)
+}
+
+func newCompleter(commands []string) *completer {
+	return &completer{
+		commands:          commands,
+		listConversations: ListConversationInfos,
+		readPrompts:       ReadPrompts,
+	}
+}
+
+// Do implements readline.AutoCompleter. It returns the candidates' remaining
+// runes after the typed text, and the length of the typed text.
+func (c *completer) Do(line []rune, pos int) ([][]rune, int) {
+	text := string(line[:pos])
+	if !strings.HasPrefix(text, "/") {
+		word := text[strings.LastIndexAny(text, " \t")+1:]
+		if strings.HasPrefix(word, "@") {
+			return completeWith(word[1:], completePaths(word[1:]))
+		}
+		return nil, 0
+	}
+
+	name, arg, hasArg := strings.Cut(text[1:], " ")
+	if !hasArg {
+		return completeWith(name, mapSlice(c.commands, func(cmd string) string { return cmd + " " }))
+	}
+	switch name {
+	case "load", "delete":
+		return completeWith(arg, c.conversationCandidates())
+	case "prompt":
+		return completeWith(arg, c.promptCandidates())
+	case "run":
+		if !strings.Contains(arg, " ") {
+			return completeWith(arg, c.promptCandidates())
+		}
+	case "export":
+		if !strings.Contains(arg, " ") {
+			return completeWith(arg, sortedKeys(exportFormats))
+		}
+	case "file":
+		word := arg[strings.LastIndex(arg, " ")+1:]
+		return completeWith(word, completePaths(word))
+	}
+	return nil, 0
+}
+
+// completeWith returns the candidates starting with typed, without the typed part.
+func completeWith(typed string, candidates []string) ([][]rune, int) {
+	var suffixes [][]rune
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, typed) && candidate != typed {
+			suffixes = append(suffixes, []rune(candidate[len(typed):]))
+		}
+	}
+	return suffixes, len([]rune(typed))
+}
+
+// conversationCandidates returns the IDs and titles of the saved conversations.
+func (c *completer) conversationCandidates() []string {
+	conversations, err := c.listConversations()
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	for _, conv := range conversations {
+		candidates = append(candidates, conv.ID)
+		if conv.Title != "" {
+			candidates = append(candidates, conv.Title)
+		}
+	}
+	return candidates
+}
+
+func (c *completer) promptCandidates() []string {
+	prompts, err := c.readPrompts()
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for name := range prompts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completePaths returns the files and directories, with a trailing slash,
+// in the directory part of typed.
+func completePaths(typed string) []string {
+	dir, _ := filepath.Split(typed)
+	readDir := dir
+	if readDir == "" {
+		readDir = "."
+	}
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for _, entry := range entries {
+		path := dir + entry.Name()
+		if entry.IsDir() {
+			path += "/"
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
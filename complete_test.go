@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func completions(c *completer, line string) []string {
+	suffixes, _ := c.Do([]rune(line), len([]rune(line)))
+	var result []string
+	for _, suffix := range suffixes {
+		result = append(result, line+string(suffix))
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestCompleter(t *testing.T) {
+	c := &completer{
+		commands: newCommandRegistry(map[string]UserCommand{"explain": {Prompt: "explain"}}).names(),
+		listConversations: func() ([]ConversationInfo, error) {
+			return []ConversationInfo{
+				{ID: "3f2a-1111", Title: "Go generics"},
+				{ID: "3f9b-2222", Title: "日本語の質問"},
+			}, nil
+		},
+		readPrompts: func() (map[string]*Prompt, error) {
+			return map[string]*Prompt{"review": {}, "refactor": {}, "translate": {}}, nil
+		},
+	}
+
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"/he", []string{"/help "}},
+		{"/sa", []string{"/save "}},
+		{"/expl", []string{"/explain "}},
+		{"/ed", []string{"/edit ", "/edit-message "}},
+		{"/load 3f", []string{"/load 3f2a-1111", "/load 3f9b-2222"}},
+		{"/delete Go", []string{"/delete Go generics"}},
+		{"/load 日本", []string{"/load 日本語の質問"}},
+		{"/prompt re", []string{"/prompt refactor", "/prompt review"}},
+		{"/run tr", []string{"/run translate"}},
+		{"/export js", []string{"/export json", "/export jsonl"}},
+		{"/run translate #", nil},
+		{"/save x", nil},
+		{"hello", nil},
+	}
+	for _, test := range tests {
+		got := completions(c, test.line)
+		if len(got) != len(test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.line, test.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.expected[i] {
+				t.Errorf("%q: expected %q, got %q", test.line, test.expected, got)
+				break
+			}
+		}
+	}
+
+	_, length := c.Do([]rune("/load 日本"), len([]rune("/load 日本")))
+	if length != 2 {
+		t.Errorf("Expected the typed length in runes, got %d", length)
+	}
+}
+
+func TestCompletePaths(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"main.go":        "",
+		"main_test.go":   "",
+		"testdata/a.yml": "",
+	})
+	origDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	defer func() {
+		if err := os.Chdir(origDir); err != nil {
+			t.Errorf("Failed to restore working directory: %v", err)
+		}
+	}()
+
+	c := &completer{}
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"/file ma", []string{"/file main.go", "/file main_test.go"}},
+		{"/file main.go te", []string{"/file main.go testdata/"}},
+		{"/file testdata/", []string{"/file testdata/a.yml"}},
+		{"look at @main_", []string{"look at @main_test.go"}},
+		{"/file " + filepath.Join(dir, "testdata") + "/", []string{"/file " + filepath.Join(dir, "testdata", "a.yml")}},
+	}
+	for _, test := range tests {
+		got := completions(c, test.line)
+		if len(got) != len(test.expected) || (len(got) > 0 && got[len(got)-1] != test.expected[len(test.expected)-1]) {
+			t.Errorf("%q: expected %q, got %q", test.line, test.expected, got)
+		}
+	}
+}
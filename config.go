@@ -3,10 +3,38 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"time"
 )
 
 type Config struct {
-	Model string `yaml:"model"`
+	Model string      `yaml:"model"`
+	Cache CacheConfig `yaml:"cache"`
+	// EditorTemplate pre-fills the editor opened by /edit-message.
+	EditorTemplate string `yaml:"editor_template"`
+	// Commands are slash commands defined by the user, keyed by name.
+	Commands map[string]UserCommand `yaml:"commands"`
+	// Prices override the built-in model prices used to estimate the session cost.
+	Prices map[string]ModelPrice `yaml:"prices"`
+	// ShowUsage shows the context usage in the chat prompt.
+	ShowUsage bool `yaml:"show_usage"`
+	// AutoTitle names new conversations with TitleModel after the first reply.
+	AutoTitle bool `yaml:"auto_title"`
+	// TitleModel is the model that names conversations, gpt-4o-mini by default.
+	TitleModel string `yaml:"title_model"`
+	// History selects where conversations are saved.
+	History HistoryConfig `yaml:"history"`
+}
+
+// CacheConfig controls the on-disk response cache used in prompt mode.
+type CacheConfig struct {
+	// Enabled turns the cache on without passing --cache.
+	Enabled bool `yaml:"enabled"`
+	// TTL is how long an entry is replayed before it is considered stale.
+	TTL time.Duration `yaml:"ttl"`
+	// MaxEntrySize is the largest response, in bytes, that will be stored.
+	MaxEntrySize int64 `yaml:"max_entry_size"`
+	// MaxSize is the total size, in bytes, the cache directory may grow to.
+	MaxSize int64 `yaml:"max_size"`
 }
 
 func ReadConfig() (*Config, error) {
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// editorCommand returns the user's editor command, split into words.
+func editorCommand() []string {
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(name)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{"vi"}
+}
+
+// composeInEditor opens the user's editor on a temporary file containing
+// initial and returns what was saved, trimmed.
+func composeInEditor(initial string) (string, error) {
+	file, err := os.CreateTemp("", "aichat-*.md")
+	if err != nil {
+		return "", err
+	}
+	path := file.Name()
+	defer func() {
+		_ = os.Remove(path)
+	}()
+	if _, err := file.WriteString(initial); err != nil {
+		_ = file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	command := editorCommand()
+	cmd := exec.Command(command[0], append(command[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("editor %s: %w", command[0], err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// composeMessage lets the user write the next message in an editor, starting
+// from initial. It returns an empty string when nothing should be sent.
+func (aiChat *AIChat) composeMessage(initial string) string {
+	content, err := composeInEditor(initial)
+	if err != nil {
+		fmt.Printf("Error: %v; message not sent.\n", err)
+		return ""
+	}
+	if content == "" {
+		fmt.Println("Empty message; not sent.")
+		return ""
+	}
+	fmt.Println(content)
+	return content
+}
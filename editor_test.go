@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeTestEditor creates an editor script running body with the file as $1.
+func writeTestEditor(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "editor")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0700); err != nil {
+		t.Fatalf("Failed to write editor script: %v", err)
+	}
+	return path
+}
+
+func TestComposeInEditor(t *testing.T) {
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", writeTestEditor(t, `printf ' edited\n' >> "$1"`))
+
+	content, err := composeInEditor("Template:")
+	if err != nil {
+		t.Fatalf("composeInEditor() returned an error: %v", err)
+	}
+	if content != "Template: edited" {
+		t.Errorf("Expected %q, got %q", "Template: edited", content)
+	}
+}
+
+func TestComposeInEditorFailure(t *testing.T) {
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", writeTestEditor(t, "exit 1"))
+
+	if _, err := composeInEditor(""); err == nil {
+		t.Error("Expected an error when the editor exits non-zero")
+	}
+
+	aiChat := &AIChat{}
+	if got := aiChat.composeMessage("ignored"); got != "" {
+		t.Errorf("Expected nothing to send after a failed edit, got %q", got)
+	}
+
+	t.Setenv("EDITOR", writeTestEditor(t, `: > "$1"`))
+	if got := aiChat.composeMessage("cleared"); got != "" {
+		t.Errorf("Expected nothing to send for an empty file, got %q", got)
+	}
+}
+
+func TestEditorCommand(t *testing.T) {
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "code --wait")
+	if got := editorCommand(); len(got) != 2 || got[0] != "code" || got[1] != "--wait" {
+		t.Errorf("Expected [code --wait], got %q", got)
+	}
+	t.Setenv("VISUAL", "nano")
+	if got := editorCommand(); len(got) != 1 || got[0] != "nano" {
+		t.Errorf("Expected VISUAL to take precedence, got %q", got)
+	}
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "")
+	if got := editorCommand(); len(got) != 1 || got[0] != "vi" {
+		t.Errorf("Expected vi as the default, got %q", got)
+	}
+}
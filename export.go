@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// exportFormats are the formats of --export and /export, with their file extensions.
+var exportFormats = map[string]string{
+	"md":    ".md",
+	"json":  ".json",
+	"html":  ".html",
+	"jsonl": ".jsonl",
+}
+
+// ExportConversations writes the conversations to w in the format. Markdown,
+// HTML and JSONL contain the active branch of each conversation; JSON keeps
+// the whole message tree.
+func ExportConversations(w io.Writer, conversations []*Conversation, format string) error {
+	switch format {
+	case "md":
+		return exportMarkdown(w, conversations)
+	case "json":
+		return exportJSON(w, conversations)
+	case "html":
+		return exportHTML(w, conversations)
+	case "jsonl":
+		return exportFineTuning(w, conversations)
+	default:
+		return fmt.Errorf("unknown export format %q, use md, json, html or jsonl", format)
+	}
+}
+
+// exportFile writes the conversations to path, or to standard output when
+// path is empty or "-".
+func exportFile(path string, conversations []*Conversation, format string) error {
+	if _, ok := exportFormats[format]; !ok {
+		return fmt.Errorf("unknown export format %q, use md, json, html or jsonl", format)
+	}
+	if path == "" || path == "-" {
+		return ExportConversations(os.Stdout, conversations, format)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := ExportConversations(file, conversations, format); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// conversationsToExport returns the conversation named by query, or all the
+// conversations matching the filter when query is "all", oldest first.
+func conversationsToExport(query string, filter ConversationFilter, choose conversationChooser) ([]*Conversation, error) {
+	if query != "all" {
+		id, err := FindConversationID(query, choose)
+		if err != nil {
+			return nil, err
+		}
+		conv, err := LoadConversation(id)
+		if err != nil {
+			return nil, err
+		}
+		return []*Conversation{conv}, nil
+	}
+	infos, err := ListConversationInfos()
+	if err != nil {
+		return nil, err
+	}
+	var conversations []*Conversation
+	for _, info := range infos {
+		if !filter.Match(info) {
+			continue
+		}
+		conv, err := LoadConversation(info.ID)
+		if err != nil {
+			return nil, err
+		}
+		conversations = append(conversations, conv)
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversations[i].CreatedAt.Before(conversations[j].CreatedAt)
+	})
+	return conversations, nil
+}
+
+func exportMarkdown(w io.Writer, conversations []*Conversation) error {
+	var b strings.Builder
+	for i, conv := range conversations {
+		if i > 0 {
+			b.WriteString("\n---\n\n")
+		}
+		fmt.Fprintf(&b, "# %s\n\n", conv.Title)
+		fmt.Fprintf(&b, "_%s, %s_\n", conv.Model, conv.CreatedAt.Local().Format("2006-01-02 15:04"))
+		for _, msg := range conv.Path() {
+			fmt.Fprintf(&b, "\n## %s\n\n%s\n", msg.Role, strings.TrimSpace(msg.Content))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type exportedMessage struct {
+	ID          string           `json:"id"`
+	ParentID    string           `json:"parent_id,omitempty"`
+	Role        string           `json:"role"`
+	Content     string           `json:"content"`
+	Time        time.Time        `json:"time"`
+	Attachments []string         `json:"attachments,omitempty"`
+	Metadata    *MessageMetadata `json:"metadata,omitempty"`
+}
+
+type exportedConversation struct {
+	ID         string            `json:"id"`
+	Title      string            `json:"title"`
+	Model      string            `json:"model"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	ActiveLeaf string            `json:"active_leaf,omitempty"`
+	Messages   []exportedMessage `json:"messages"`
+	Tags       []string          `json:"tags,omitempty"`
+	Pinned     bool              `json:"pinned,omitempty"`
+	Archived   bool              `json:"archived,omitempty"`
+}
+
+// exportJSON writes a single conversation as an object and several as an array.
+func exportJSON(w io.Writer, conversations []*Conversation) error {
+	exported := mapSlice(conversations, func(conv *Conversation) exportedConversation {
+		return exportedConversation{
+			ID:         conv.ID,
+			Title:      conv.Title,
+			Model:      conv.Model,
+			CreatedAt:  conv.CreatedAt,
+			UpdatedAt:  conv.UpdatedAt,
+			ActiveLeaf: conv.ActiveLeaf,
+			Tags:       conv.Tags,
+			Pinned:     conv.Pinned,
+			Archived:   conv.Archived,
+			Messages: mapSlice(conv.Messages, func(msg ChatMessage) exportedMessage {
+				return exportedMessage(msg)
+			}),
+		}
+	})
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if len(exported) == 1 {
+		return encoder.Encode(exported[0])
+	}
+	return encoder.Encode(exported)
+}
+
+type fineTuningMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// exportFineTuning writes one line per conversation in the format of
+// OpenAI's chat fine-tuning. Conversations without a reply are skipped, as
+// there is nothing to learn from them.
+func exportFineTuning(w io.Writer, conversations []*Conversation) error {
+	for _, conv := range conversations {
+		if conv.LastIndexOfRole(gogpt.ChatMessageRoleAssistant) < 0 {
+			continue
+		}
+		line, err := json.Marshal(struct {
+			Messages []fineTuningMessage `json:"messages"`
+		}{
+			Messages: mapSlice(conv.Path(), func(msg ChatMessage) fineTuningMessage {
+				return fineTuningMessage{Role: msg.Role, Content: msg.Content}
+			}),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// htmlExport is a self-contained page: the styles are inline and the
+// messages are preformatted text, so it needs nothing else to be viewed.
+var htmlExport = template.Must(template.New("export").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{if eq (len .) 1}}{{(index . 0).Title}}{{else}}Conversations{{end}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; max-width: 48em; margin: 2em auto; padding: 0 1em; color: #222; }
+header p, .time { color: #777; font-size: 0.85em; }
+.message { border-radius: 8px; padding: 0.5em 1em; margin: 1em 0; }
+.message pre { white-space: pre-wrap; word-wrap: break-word; font-family: inherit; margin: 0.5em 0; }
+.role { font-weight: bold; text-transform: capitalize; }
+.user { background: #eef4ff; }
+.assistant { background: #f4f4f4; }
+.system { background: #fff8e1; }
+section + section { border-top: 1px solid #ddd; margin-top: 3em; }
+</style>
+</head>
+<body>
+{{range .}}<section id="{{.ID}}">
+<header>
+<h1>{{.Title}}</h1>
+<p>{{.Model}} · {{date .CreatedAt}}</p>
+</header>
+{{range .Path}}<div class="message {{.Role}}">
+<span class="role">{{.Role}}</span> <span class="time">{{date .Time}}</span>
+<pre>{{.Content}}</pre>
+</div>
+{{end}}</section>
+{{end}}</body>
+</html>
+`))
+
+func exportHTML(w io.Writer, conversations []*Conversation) error {
+	return htmlExport.Execute(w, conversations)
+}
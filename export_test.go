@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testExportConversation() *Conversation {
+	conv := NewConversation("Greetings <b>", "gpt-4")
+	conv.CreatedAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	conv.AddMessage("system", "Be brief.")
+	conv.AddMessage("user", "Hello")
+	conv.AddMessage("assistant", "Hi!")
+	conv.Rewind(2)
+	conv.AddMessage("assistant", "Hello <there> & welcome")
+	return conv
+}
+
+func TestExportMarkdown(t *testing.T) {
+	var out strings.Builder
+	if err := ExportConversations(&out, []*Conversation{testExportConversation()}, "md"); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	got := out.String()
+	if !strings.HasPrefix(got, "# Greetings <b>\n\n_gpt-4, ") {
+		t.Errorf("Expected a title and the model, got:\n%s", got)
+	}
+	if !strings.Contains(got, "## user\n\nHello\n\n## assistant\n\nHello <there> & welcome\n") || strings.Contains(got, "Hi!") {
+		t.Errorf("Expected the active branch only, got:\n%s", got)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	conv := testExportConversation()
+	var out strings.Builder
+	if err := ExportConversations(&out, []*Conversation{conv}, "json"); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	var exported exportedConversation
+	if err := json.Unmarshal([]byte(out.String()), &exported); err != nil {
+		t.Fatalf("Expected a JSON object: %v\n%s", err, out.String())
+	}
+	if exported.ID != conv.ID || len(exported.Messages) != 4 || exported.ActiveLeaf != conv.ActiveLeaf {
+		t.Errorf("Expected the whole tree, got %+v", exported)
+	}
+
+	out.Reset()
+	if err := ExportConversations(&out, []*Conversation{conv, conv}, "json"); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	var list []exportedConversation
+	if err := json.Unmarshal([]byte(out.String()), &list); err != nil || len(list) != 2 {
+		t.Errorf("Expected an array of conversations, got %v\n%s", err, out.String())
+	}
+}
+
+func TestExportFineTuning(t *testing.T) {
+	unanswered := NewConversation("Unanswered", "gpt-4")
+	unanswered.AddMessage("user", "Hello?")
+	var out strings.Builder
+	if err := ExportConversations(&out, []*Conversation{testExportConversation(), unanswered}, "jsonl"); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected conversations without a reply to be skipped, got %q", lines)
+	}
+	var example struct {
+		Messages []fineTuningMessage `json:"messages"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &example); err != nil {
+		t.Fatalf("Expected a JSON line: %v", err)
+	}
+	if len(example.Messages) != 3 || example.Messages[0].Role != "system" || example.Messages[2].Content != "Hello <there> & welcome" {
+		t.Errorf("Unexpected example: %+v", example)
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	var out strings.Builder
+	if err := ExportConversations(&out, []*Conversation{testExportConversation()}, "html"); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	got := out.String()
+	if !strings.Contains(got, "<title>Greetings &lt;b&gt;</title>") || !strings.Contains(got, "Hello &lt;there&gt; &amp; welcome") {
+		t.Errorf("Expected the content to be escaped, got:\n%s", got)
+	}
+	if !strings.Contains(got, "<style>") || strings.Contains(got, "<link") || strings.Contains(got, "<script") {
+		t.Errorf("Expected a self-contained page, got:\n%s", got)
+	}
+}
+
+func TestConversationsToExport(t *testing.T) {
+	useTestHistory(t)
+	for _, model := range []string{"gpt-4", "gpt-4o", "gpt-4"} {
+		conv := NewConversation("Chat with "+model, model)
+		conv.AddMessage("user", "Hi")
+		if err := SaveConversation(conv); err != nil {
+			t.Fatalf("Failed to save conversation: %v", err)
+		}
+	}
+
+	all, err := conversationsToExport("all", ConversationFilter{}, nil)
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Expected all conversations, got %d, %v", len(all), err)
+	}
+	filtered, err := conversationsToExport("all", ConversationFilter{Model: "gpt-4"}, nil)
+	if err != nil || len(filtered) != 2 {
+		t.Errorf("Expected the model filter to apply, got %d, %v", len(filtered), err)
+	}
+	one, err := conversationsToExport("Chat with gpt-4o", ConversationFilter{}, nil)
+	if err != nil || len(one) != 1 || one[0].Model != "gpt-4o" {
+		t.Errorf("Expected the conversation to be found by title, got %+v, %v", one, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "out.md")
+	if err := exportFile(path, all, "md"); err != nil {
+		t.Fatalf("exportFile failed: %v", err)
+	}
+	if data, err := os.ReadFile(path); err != nil || strings.Count(string(data), "\n---\n") != 2 {
+		t.Errorf("Expected three conversations in the file, got %q, %v", data, err)
+	}
+	if err := exportFile(path, all, "pdf"); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+}
@@ -1,30 +1,90 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	gogpt "github.com/sashabaranov/go-openai"
-	"gopkg.in/yaml.v3"
 )
 
+// ChatMessage is a node of the conversation tree. Messages without a parent
+// start the conversation; several children of the same parent are
+// alternatives produced by retrying or editing.
 type ChatMessage struct {
-	Role    string    `yaml:"role"`
-	Content string    `yaml:"content"`
-	Time    time.Time `yaml:"time"`
+	ID       string    `yaml:"id,omitempty"`
+	ParentID string    `yaml:"parent_id,omitempty"`
+	Role     string    `yaml:"role"`
+	Content  string    `yaml:"content"`
+	Time     time.Time `yaml:"time"`
+	// Attachments are the paths of the files included in Content.
+	Attachments []string `yaml:"attachments,omitempty"`
+	// Metadata describes how an assistant message was generated.
+	Metadata *MessageMetadata `yaml:"metadata,omitempty"`
 }
 
+// MessageMetadata records the request that produced a reply and how it went.
+type MessageMetadata struct {
+	// Model is the model that answered, as reported by the API.
+	Model       string  `yaml:"model" json:"model"`
+	Temperature float32 `yaml:"temperature" json:"temperature"`
+	// MaxTokens is the completion limit of the request, 0 for the default.
+	MaxTokens        int  `yaml:"max_tokens,omitempty" json:"max_tokens,omitempty"`
+	Streamed         bool `yaml:"streamed,omitempty" json:"streamed,omitempty"`
+	PromptTokens     int  `yaml:"prompt_tokens" json:"prompt_tokens"`
+	CompletionTokens int  `yaml:"completion_tokens" json:"completion_tokens"`
+	// EstimatedUsage is set when the API reported no usage and the tokens
+	// were counted locally.
+	EstimatedUsage bool `yaml:"estimated_usage,omitempty" json:"estimated_usage,omitempty"`
+	// TimeToFirstToken is only known for streamed replies.
+	TimeToFirstToken time.Duration `yaml:"time_to_first_token,omitempty" json:"time_to_first_token,omitempty"`
+	Latency          time.Duration `yaml:"latency" json:"latency"`
+	FinishReason     string        `yaml:"finish_reason,omitempty" json:"finish_reason,omitempty"`
+}
+
+// ChatSettings are the request parameters of a chat session besides the model.
+type ChatSettings struct {
+	Temperature  float32 `yaml:"temperature"`
+	MaxTokens    int     `yaml:"max_tokens"`
+	NonStreaming bool    `yaml:"non_streaming"`
+}
+
+// Conversation is a tree of messages. Messages holds every node in the order
+// they were added, and ActiveLeaf selects the branch that is shown and
+// continued; use Path to get it.
 type Conversation struct {
-	ID        string        `yaml:"id"`
-	Title     string        `yaml:"title"`
-	Messages  []ChatMessage `yaml:"messages"`
-	CreatedAt time.Time     `yaml:"created_at"`
-	UpdatedAt time.Time     `yaml:"updated_at"`
-	Model     string        `yaml:"model"`
+	ID         string        `yaml:"id"`
+	Title      string        `yaml:"title"`
+	Messages   []ChatMessage `yaml:"messages"`
+	ActiveLeaf string        `yaml:"active_leaf,omitempty"`
+	CreatedAt  time.Time     `yaml:"created_at"`
+	UpdatedAt  time.Time     `yaml:"updated_at"`
+	Model      string        `yaml:"model"`
+	Settings   *ChatSettings `yaml:"settings,omitempty"`
+	Prompt     string        `yaml:"prompt,omitempty"`
+	// Untitled is set on a new conversation until it is titled after its
+	// first reply, or named by the user.
+	Untitled bool `yaml:"untitled,omitempty"`
+	// Tags label the conversation for filtering; they are kept sorted.
+	Tags     []string `yaml:"tags,omitempty"`
+	Pinned   bool     `yaml:"pinned,omitempty"`
+	Archived bool     `yaml:"archived,omitempty"`
+	// Revision counts the writes of the saved copy. Every store write bumps
+	// it, so that a session can tell whether the saved copy changed since it
+	// was loaded, even by a change that kept UpdatedAt.
+	Revision int `yaml:"revision,omitempty"`
+
+	// savedAt is UpdatedAt of the saved copy this conversation was loaded
+	// from or last saved as, and zero for a conversation never saved.
+	savedAt time.Time
 }
 
 func NewConversation(title, model string) *Conversation {
@@ -35,21 +95,181 @@ func NewConversation(title, model string) *Conversation {
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 		Model:     model,
+		Untitled:  true,
 	}
 }
 
+// AddMessage appends a message to the active branch.
 func (c *Conversation) AddMessage(role, content string) {
+	id := c.newMessageID()
 	c.Messages = append(c.Messages, ChatMessage{
-		Role:    role,
-		Content: content,
-		Time:    time.Now(),
+		ID:       id,
+		ParentID: c.ActiveLeaf,
+		Role:     role,
+		Content:  content,
+		Time:     time.Now(),
 	})
+	c.ActiveLeaf = id
+	c.UpdatedAt = time.Now()
+}
+
+func (c *Conversation) newMessageID() string {
+	for n := len(c.Messages) + 1; ; n++ {
+		id := strconv.Itoa(n)
+		if c.Message(id) == nil {
+			return id
+		}
+	}
+}
+
+// Message returns the message with the ID, or nil.
+func (c *Conversation) Message(id string) *ChatMessage {
+	for i := range c.Messages {
+		if c.Messages[i].ID == id {
+			return &c.Messages[i]
+		}
+	}
+	return nil
+}
+
+// Children returns the messages whose parent is parentID, oldest first.
+// An empty parentID returns the messages that start the conversation.
+func (c *Conversation) Children(parentID string) []ChatMessage {
+	var children []ChatMessage
+	for _, msg := range c.Messages {
+		if msg.ParentID == parentID {
+			children = append(children, msg)
+		}
+	}
+	return children
+}
+
+// Path returns the active branch from its first message to ActiveLeaf.
+func (c *Conversation) Path() []ChatMessage {
+	var path []ChatMessage
+	// a damaged file may link its messages in a cycle
+	seen := map[string]bool{}
+	for id := c.ActiveLeaf; id != "" && !seen[id]; {
+		msg := c.Message(id)
+		if msg == nil {
+			break
+		}
+		seen[id] = true
+		path = append(path, *msg)
+		id = msg.ParentID
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// Rewind makes the first n messages of the active branch the active branch,
+// so that the next message starts an alternative. Later messages are kept.
+func (c *Conversation) Rewind(n int) {
+	path := c.Path()
+	if n >= len(path) {
+		return
+	}
+	if n <= 0 {
+		c.ActiveLeaf = ""
+	} else {
+		c.ActiveLeaf = path[n-1].ID
+	}
 	c.UpdatedAt = time.Now()
 }
 
+// Switch activates the branch containing the message with the ID, following
+// the most recent alternatives below it down to a leaf.
+func (c *Conversation) Switch(id string) error {
+	if c.Message(id) == nil {
+		return fmt.Errorf("no message with ID %s", id)
+	}
+	for seen := map[string]bool{id: true}; ; {
+		children := c.Children(id)
+		if len(children) == 0 || seen[children[len(children)-1].ID] {
+			break
+		}
+		id = children[len(children)-1].ID
+		seen[id] = true
+	}
+	c.ActiveLeaf = id
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+// LastIndexOfRole returns the index in the active branch of the last message
+// with the role, or -1.
+func (c *Conversation) LastIndexOfRole(role string) int {
+	path := c.Path()
+	for i := len(path) - 1; i >= 0; i-- {
+		if path[i].Role == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// HasTag reports whether the conversation has the tag, ignoring case.
+func (c *Conversation) HasTag(tag string) bool {
+	for _, t := range c.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTags adds the tags the conversation does not have yet. A leading # is
+// dropped, so that "#work" and "work" are the same tag.
+func (c *Conversation) AddTags(tags ...string) {
+	for _, tag := range tags {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+		if tag != "" && !c.HasTag(tag) {
+			c.Tags = append(c.Tags, tag)
+		}
+	}
+	sort.Strings(c.Tags)
+}
+
+// RemoveTags removes the tags, ignoring the ones the conversation does not have.
+func (c *Conversation) RemoveTags(tags ...string) {
+	kept := c.Tags[:0]
+	for _, t := range c.Tags {
+		remove := false
+		for _, tag := range tags {
+			remove = remove || strings.EqualFold(t, strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+		}
+		if !remove {
+			kept = append(kept, t)
+		}
+	}
+	c.Tags = kept
+	if len(c.Tags) == 0 {
+		c.Tags = nil
+	}
+}
+
+// normalize turns a conversation saved as a flat list of messages, before
+// messages had IDs, into a single branch.
+func (c *Conversation) normalize() {
+	if len(c.Messages) == 0 || c.Messages[0].ID != "" {
+		return
+	}
+	parentID := ""
+	for i := range c.Messages {
+		c.Messages[i].ID = strconv.Itoa(i + 1)
+		c.Messages[i].ParentID = parentID
+		parentID = c.Messages[i].ID
+	}
+	c.ActiveLeaf = parentID
+}
+
+// ToGPTMessages returns the active branch as request messages.
 func (c *Conversation) ToGPTMessages() []gogpt.ChatCompletionMessage {
-	messages := make([]gogpt.ChatCompletionMessage, len(c.Messages))
-	for i, msg := range c.Messages {
+	path := c.Path()
+	messages := make([]gogpt.ChatCompletionMessage, len(path))
+	for i, msg := range path {
 		messages[i] = gogpt.ChatCompletionMessage{
 			Role:    msg.Role,
 			Content: msg.Content,
@@ -58,14 +278,12 @@ func (c *Conversation) ToGPTMessages() []gogpt.ChatCompletionMessage {
 	return messages
 }
 
+// FromGPTMessages replaces the conversation with a single branch of messages.
 func (c *Conversation) FromGPTMessages(messages []gogpt.ChatCompletionMessage) {
-	c.Messages = make([]ChatMessage, len(messages))
-	for i, msg := range messages {
-		c.Messages[i] = ChatMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-			Time:    time.Now(),
-		}
+	c.Messages = []ChatMessage{}
+	c.ActiveLeaf = ""
+	for _, msg := range messages {
+		c.AddMessage(msg.Role, msg.Content)
 	}
 	c.UpdatedAt = time.Now()
 }
@@ -86,93 +304,122 @@ var GetHistoryDir GetHistoryDirFunc = func() (string, error) {
 	return historyDir, nil
 }
 
+// errSaveConflict is returned by SaveConversation when another session
+// saved the conversation after it was loaded.
+var errSaveConflict = errors.New("the conversation was saved by another session since it was loaded")
+
+// SaveConversation stores the conversation in the history store. It fails
+// with errSaveConflict rather than overwrite messages saved by another session.
 func SaveConversation(conversation *Conversation) error {
-	historyDir, err := GetHistoryDir()
-	if err != nil {
-		return err
-	}
-	
-	conversation.UpdatedAt = time.Now()
-	
-	filePath := filepath.Join(historyDir, fmt.Sprintf("%s.yml", conversation.ID))
-	
-	data, err := yaml.Marshal(conversation)
-	if err != nil {
-		return err
-	}
-	
-	return os.WriteFile(filePath, data, 0600)
+	return withHistoryLock(func() error {
+		stored, err := LoadConversation(conversation.ID)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err == nil && stored.Revision != conversation.Revision {
+			return errSaveConflict
+		}
+		conversation.UpdatedAt = time.Now()
+		if err := historyStore.Save(conversation); err != nil {
+			return err
+		}
+		indexConversations(conversation)
+		conversation.savedAt = conversation.UpdatedAt
+		return nil
+	})
 }
 
 func LoadConversation(id string) (*Conversation, error) {
-	historyDir, err := GetHistoryDir()
+	return historyStore.Load(id)
+}
+
+// ListConversations returns the saved conversations, most recently updated first.
+func ListConversations() ([]*Conversation, error) {
+	conversations, err := historyStore.List()
 	if err != nil {
 		return nil, err
 	}
-	
-	filePath := filepath.Join(historyDir, fmt.Sprintf("%s.yml", id))
-	
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, err
+	sort.SliceStable(conversations, func(i, j int) bool {
+		return conversations[i].UpdatedAt.After(conversations[j].UpdatedAt)
+	})
+	return conversations, nil
+}
+
+func DeleteConversation(id string) error {
+	if err := historyStore.Delete(id); err != nil {
+		return err
 	}
-	
-	conversation := &Conversation{}
-	if err := yaml.Unmarshal(data, conversation); err != nil {
-		return nil, err
+	indexConversations()
+	return nil
+}
+
+// Conversation statuses of ConversationFilter.
+const (
+	statusAll      = "all"
+	statusActive   = "active"
+	statusPinned   = "pinned"
+	statusArchived = "archived"
+)
+
+// parseStatus checks a status given by the user; empty means all.
+func parseStatus(status string) (string, error) {
+	switch status {
+	case "", statusAll, statusActive, statusPinned, statusArchived:
+		return status, nil
+	default:
+		return "", fmt.Errorf("unknown status %q, use active, pinned, archived or all", status)
 	}
-	
-	return conversation, nil
 }
 
-func ListConversations() ([]*Conversation, error) {
-	historyDir, err := GetHistoryDir()
-	if err != nil {
-		return nil, err
+// ConversationFilter selects conversations. Zero values match everything.
+type ConversationFilter struct {
+	Model string
+	// Since and Until bound the time the conversation was last updated.
+	Since time.Time
+	Until time.Time
+	// Tags must all be on the conversation.
+	Tags []string
+	// Status is active (not archived), pinned, archived or all.
+	Status string
+}
+
+// Match reports whether the conversation passes the filter.
+func (f ConversationFilter) Match(c ConversationInfo) bool {
+	if f.Model != "" && c.Model != f.Model {
+		return false
 	}
-	
-	files, err := os.ReadDir(historyDir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []*Conversation{}, nil
-		}
-		return nil, err
+	if !f.Since.IsZero() && c.UpdatedAt.Before(f.Since) {
+		return false
 	}
-	
-	conversations := []*Conversation{}
-	
-	for _, file := range files {
-		if file.IsDir() {
-			continue
-		}
-		
-		if !strings.HasSuffix(file.Name(), ".yml") {
-			continue
-		}
-		
-		id := filepath.Base(file.Name())
-		id = id[:len(id)-4] // Remove .yml extension
-		
-		conversation, err := LoadConversation(id)
-		if err != nil {
-			continue // Skip files that can't be loaded
+	if !f.Until.IsZero() && !c.UpdatedAt.Before(f.Until) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !slices.ContainsFunc(c.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			return false
 		}
-		
-		conversations = append(conversations, conversation)
 	}
-	
-	return conversations, nil
+	switch f.Status {
+	case statusActive:
+		return !c.Archived
+	case statusPinned:
+		return c.Pinned
+	case statusArchived:
+		return c.Archived
+	}
+	return true
 }
 
-func DeleteConversation(id string) error {
-	historyDir, err := GetHistoryDir()
+// LatestConversationID returns the ID of the most recently updated conversation.
+func LatestConversationID() (string, error) {
+	infos, err := ListConversationInfos()
 	if err != nil {
-		return err
+		return "", err
 	}
-	
-	filePath := filepath.Join(historyDir, fmt.Sprintf("%s.yml", id))
-	
-	return os.Remove(filePath)
+	if len(infos) == 0 {
+		return "", fmt.Errorf("no saved conversations")
+	}
+	return infos[0].ID, nil
 }
 
 func GetConversationTitle(messages []gogpt.ChatCompletionMessage) string {
@@ -182,11 +429,12 @@ func GetConversationTitle(messages []gogpt.ChatCompletionMessage) string {
 	
 	for _, msg := range messages {
 		if msg.Role == gogpt.ChatMessageRoleUser {
-			title := msg.Content
+			// cut on characters, not bytes, to keep multi-byte text valid
+			title := []rune(strings.Join(strings.Fields(msg.Content), " "))
 			if len(title) > 30 {
-				title = title[:29] + "..."
+				return string(title[:29]) + "..."
 			}
-			return title
+			return string(title)
 		}
 	}
 	
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -146,22 +147,7 @@ func TestGetConversationTitle(t *testing.T) {
 }
 
 func TestSaveLoadConversation(t *testing.T) {
-	tempDir, err := os.MkdirTemp("", "aichat-test")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-	defer func() {
-		if err := os.RemoveAll(tempDir); err != nil {
-			t.Logf("Failed to remove temp dir: %v", err)
-		}
-	}()
-	
-	origGetHistoryDir := GetHistoryDir
-	defer func() { GetHistoryDir = origGetHistoryDir }()
-	
-	GetHistoryDir = func() (string, error) {
-		return tempDir, nil
-	}
+	tempDir := useTestHistory(t)
 	
 	conversation := NewConversation("Test Conversation", "gpt-3.5-turbo")
 	conversation.AddMessage("user", "Hello")
@@ -205,22 +191,7 @@ func TestSaveLoadConversation(t *testing.T) {
 }
 
 func TestListConversations(t *testing.T) {
-	tempDir, err := os.MkdirTemp("", "aichat-test")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-	defer func() {
-		if err := os.RemoveAll(tempDir); err != nil {
-			t.Logf("Failed to remove temp dir: %v", err)
-		}
-	}()
-	
-	origGetHistoryDir := GetHistoryDir
-	defer func() { GetHistoryDir = origGetHistoryDir }()
-	
-	GetHistoryDir = func() (string, error) {
-		return tempDir, nil
-	}
+	useTestHistory(t)
 	
 	conversations, err := ListConversations()
 	if err != nil {
@@ -248,27 +219,34 @@ func TestListConversations(t *testing.T) {
 	}
 	
 	if len(conversations) != 3 {
-		t.Errorf("Expected 3 conversations, got %d", len(conversations))
+		t.Fatalf("Expected 3 conversations, got %d", len(conversations))
+	}
+	if conversations[0].Title != "Test 2" || conversations[2].Title != "Test 0" {
+		t.Errorf("Expected the most recently updated first, got %q, %q, %q", conversations[0].Title, conversations[1].Title, conversations[2].Title)
 	}
 }
 
-func TestDeleteConversation(t *testing.T) {
-	tempDir, err := os.MkdirTemp("", "aichat-test")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
+func TestConversationTags(t *testing.T) {
+	conv := NewConversation("Tagged", "gpt-4")
+	conv.AddTags("work", "#Rust", "work", " ")
+	if len(conv.Tags) != 2 || conv.Tags[0] != "Rust" || conv.Tags[1] != "work" {
+		t.Errorf("Expected sorted tags without duplicates, got %q", conv.Tags)
 	}
-	defer func() {
-		if err := os.RemoveAll(tempDir); err != nil {
-			t.Logf("Failed to remove temp dir: %v", err)
-		}
-	}()
-	
-	origGetHistoryDir := GetHistoryDir
-	defer func() { GetHistoryDir = origGetHistoryDir }()
-	
-	GetHistoryDir = func() (string, error) {
-		return tempDir, nil
+	if !conv.HasTag("rust") {
+		t.Error("Expected tags to match ignoring case")
+	}
+	conv.RemoveTags("RUST", "missing")
+	if len(conv.Tags) != 1 || conv.Tags[0] != "work" {
+		t.Errorf("Expected rust to be removed, got %q", conv.Tags)
+	}
+	conv.RemoveTags("#work")
+	if conv.Tags != nil {
+		t.Errorf("Expected no tags, got %q", conv.Tags)
 	}
+}
+
+func TestDeleteConversation(t *testing.T) {
+	tempDir := useTestHistory(t)
 	
 	conversation := NewConversation("Test", "gpt-3.5-turbo")
 	conversation.AddMessage("user", "Hello")
@@ -286,8 +264,176 @@ func TestDeleteConversation(t *testing.T) {
 		t.Errorf("Expected file to be deleted: %s", filePath)
 	}
 	
-	_, err = LoadConversation(conversation.ID)
+	_, err := LoadConversation(conversation.ID)
 	if err == nil {
 		t.Error("Expected error when loading deleted conversation")
 	}
 }
+
+func TestConversationBranches(t *testing.T) {
+	conversation := NewConversation("Test", "gpt-3.5-turbo")
+	conversation.AddMessage("user", "Hello")
+	conversation.AddMessage("assistant", "Hi")
+	conversation.AddMessage("user", "How are you?")
+	conversation.AddMessage("assistant", "Fine")
+
+	conversation.Rewind(1)
+	conversation.AddMessage("assistant", "Hello!")
+
+	path := conversation.Path()
+	if len(path) != 2 || path[1].Content != "Hello!" {
+		t.Fatalf("Expected the new branch to be active, got %+v", path)
+	}
+	if len(conversation.Messages) != 5 {
+		t.Errorf("Expected the old branch to be kept, got %d messages", len(conversation.Messages))
+	}
+	children := conversation.Children(path[0].ID)
+	if len(children) != 2 || children[0].Content != "Hi" || children[1].Content != "Hello!" {
+		t.Errorf("Expected two alternatives, got %+v", children)
+	}
+
+	if err := conversation.Switch(children[0].ID); err != nil {
+		t.Fatalf("Failed to switch: %v", err)
+	}
+	path = conversation.Path()
+	if len(path) != 4 || path[3].Content != "Fine" {
+		t.Errorf("Expected to switch down to the leaf of the old branch, got %+v", path)
+	}
+	if conversation.LastIndexOfRole("user") != 2 {
+		t.Errorf("Expected the last user message at 2, got %d", conversation.LastIndexOfRole("user"))
+	}
+
+	if err := conversation.Switch("missing"); err == nil {
+		t.Error("Expected switching to an unknown message to fail")
+	}
+
+	conversation.Rewind(0)
+	conversation.AddMessage("user", "Bonjour")
+	if len(conversation.Children("")) != 2 {
+		t.Errorf("Expected two first messages, got %+v", conversation.Children(""))
+	}
+	if len(conversation.ToGPTMessages()) != 1 {
+		t.Errorf("Expected only the active branch in GPT messages, got %+v", conversation.ToGPTMessages())
+	}
+}
+
+func TestConversationCycle(t *testing.T) {
+	conversation := NewConversation("Damaged", "gpt-4")
+	conversation.Messages = []ChatMessage{
+		{ID: "1", ParentID: "2", Role: "user", Content: "one"},
+		{ID: "2", ParentID: "1", Role: "assistant", Content: "two"},
+	}
+	conversation.ActiveLeaf = "2"
+	if path := conversation.Path(); len(path) != 2 {
+		t.Errorf("Expected the cycle to be walked once, got %+v", path)
+	}
+	if err := conversation.Switch("1"); err != nil {
+		t.Errorf("Failed to switch: %v", err)
+	}
+}
+
+func TestLoadFlatConversation(t *testing.T) {
+	tempDir := useTestHistory(t)
+
+	data := `id: flat
+title: Flat
+messages:
+    - role: user
+      content: Hello
+      time: 2023-04-01T00:00:00Z
+    - role: assistant
+      content: Hi there!
+      time: 2023-04-01T00:00:01Z
+created_at: 2023-04-01T00:00:00Z
+updated_at: 2023-04-01T00:00:01Z
+model: gpt-3.5-turbo
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "flat.yml"), []byte(data), 0600); err != nil {
+		t.Fatalf("Failed to write conversation: %v", err)
+	}
+
+	loaded, err := LoadConversation("flat")
+	if err != nil {
+		t.Fatalf("Failed to load conversation: %v", err)
+	}
+	path := loaded.Path()
+	if len(path) != 2 || path[0].Content != "Hello" || path[1].Content != "Hi there!" {
+		t.Fatalf("Expected a single branch with both messages, got %+v", path)
+	}
+	if path[1].ParentID != path[0].ID {
+		t.Errorf("Expected the reply to be a child of the first message, got %+v", path)
+	}
+
+	loaded.AddMessage("user", "Again")
+	if err := SaveConversation(loaded); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	reloaded, err := LoadConversation("flat")
+	if err != nil {
+		t.Fatalf("Failed to reload conversation: %v", err)
+	}
+	if len(reloaded.Path()) != 3 || reloaded.ActiveLeaf != loaded.ActiveLeaf {
+		t.Errorf("Expected the tree to round-trip, got %+v", reloaded)
+	}
+}
+
+func TestLatestConversationID(t *testing.T) {
+	useTestHistory(t)
+
+	if _, err := LatestConversationID(); err == nil {
+		t.Error("Expected an error without saved conversations")
+	}
+	older := NewConversation("Older", "gpt-4")
+	newer := NewConversation("Newer", "gpt-4")
+	for _, conv := range []*Conversation{newer, older} {
+		if err := SaveConversation(conv); err != nil {
+			t.Fatalf("Failed to save conversation: %v", err)
+		}
+	}
+	// saving again makes it the most recently updated
+	if err := SaveConversation(newer); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	if id, err := LatestConversationID(); err != nil || id != newer.ID {
+		t.Errorf("Expected %q, got %q, %v", newer.ID, id, err)
+	}
+}
+
+func TestSaveConversationConflict(t *testing.T) {
+	tempDir := useTestHistory(t)
+
+	conv := NewConversation("Shared", "gpt-4")
+	conv.AddMessage("user", "Hello")
+	if err := SaveConversation(conv); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	// saving again after saving is not a conflict
+	conv.AddMessage("assistant", "Hi!")
+	if err := SaveConversation(conv); err != nil {
+		t.Fatalf("Failed to save conversation again: %v", err)
+	}
+
+	first, _ := LoadConversation(conv.ID)
+	second, _ := LoadConversation(conv.ID)
+	first.AddMessage("user", "From the first terminal")
+	if err := SaveConversation(first); err != nil {
+		t.Fatalf("Failed to save the first copy: %v", err)
+	}
+	second.AddMessage("user", "From the second terminal")
+	if err := SaveConversation(second); !errors.Is(err, errSaveConflict) {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+	if stored, _ := LoadConversation(conv.ID); stored.Path()[2].Content != "From the first terminal" {
+		t.Errorf("Expected the first copy to be kept, got %q", messageContents(stored))
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != conv.ID+".yml" && entry.Name() != ".lock" && entry.Name() != ".index" {
+			t.Errorf("Unexpected file left in the history: %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// importNamespace derives the IDs of imported conversations that have none,
+// so that importing the same file twice finds the duplicates.
+var importNamespace = uuid.MustParse("8f1d5c1e-2b6a-4c3e-9a57-3f0e6d2b7c41")
+
+// importSummary counts what happened to the conversations of an import.
+type importSummary struct {
+	Imported   int
+	Duplicates int
+	Empty      int
+}
+
+func (s importSummary) String() string {
+	return fmt.Sprintf("Imported %d conversations, skipped %d already imported and %d without messages.",
+		s.Imported, s.Duplicates, s.Empty)
+}
+
+// ImportFile adds the conversations in a file, or in standard input for
+// "-", to the history. Conversations already in the history are skipped.
+func ImportFile(path string) (importSummary, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return importSummary{}, err
+	}
+	conversations, empty, err := parseImport(data)
+	if err != nil {
+		return importSummary{}, fmt.Errorf("%s: %w", path, err)
+	}
+	summary := importSummary{Empty: empty}
+	var imported []*Conversation
+	defer func() {
+		if len(imported) > 0 {
+			_ = withHistoryLock(func() error {
+				indexConversations(imported...)
+				return nil
+			})
+		}
+	}()
+	for _, conv := range conversations {
+		// checked and saved under the lock, as other sessions save
+		duplicate := false
+		err := withHistoryLock(func() error {
+			if _, err := historyStore.Load(conv.ID); err == nil {
+				duplicate = true
+				return nil
+			}
+			// the store keeps the original timestamps, unlike SaveConversation
+			return historyStore.Save(conv)
+		})
+		if err != nil {
+			return summary, err
+		}
+		if duplicate {
+			summary.Duplicates++
+			continue
+		}
+		imported = append(imported, conv)
+		summary.Imported++
+	}
+	return summary, nil
+}
+
+// parseImport reads ChatGPT's conversations.json, aichat's JSON export, or
+// OpenAI chat messages: an array of messages, objects with "messages", or
+// JSON Lines of either. It returns the conversations and the number of
+// conversations without messages.
+func parseImport(data []byte) ([]*Conversation, int, error) {
+	var conversations []*Conversation
+	empty := 0
+	add := func(conv *Conversation, err error) error {
+		if err != nil {
+			return err
+		}
+		if len(conv.Messages) == 0 {
+			empty++
+			return nil
+		}
+		conversations = append(conversations, conv)
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+		value = bytes.TrimSpace(value)
+		if len(value) == 0 || value[0] != '[' {
+			if err := add(importObject(value)); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		var elements []json.RawMessage
+		if err := json.Unmarshal(value, &elements); err != nil {
+			return nil, 0, err
+		}
+		if len(elements) > 0 && hasKey(elements[0], "role") {
+			// a bare list of messages is a single conversation
+			var messages []importedMessage
+			if err := json.Unmarshal(value, &messages); err != nil {
+				return nil, 0, err
+			}
+			if err := add(importGeneric(importedConversation{Messages: messages})); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		for _, element := range elements {
+			if err := add(importObject(element)); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	return conversations, empty, nil
+}
+
+func hasKey(object json.RawMessage, key string) bool {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(object, &keys); err != nil {
+		return false
+	}
+	_, ok := keys[key]
+	return ok
+}
+
+func importObject(object json.RawMessage) (*Conversation, error) {
+	if hasKey(object, "mapping") {
+		var conv chatGPTConversation
+		if err := json.Unmarshal(object, &conv); err != nil {
+			return nil, err
+		}
+		return importChatGPT(conv), nil
+	}
+	if !hasKey(object, "messages") {
+		return nil, fmt.Errorf("unrecognized conversation: %.60s", object)
+	}
+	var conv importedConversation
+	if err := json.Unmarshal(object, &conv); err != nil {
+		return nil, err
+	}
+	return importGeneric(conv)
+}
+
+// messageText returns the text of message content, which is a string or a
+// list of parts that are strings or objects with a "text" field. Other parts,
+// such as images, are left out.
+func messageText(content json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(content, &text); err == nil {
+		return text
+	}
+	var parts []json.RawMessage
+	if err := json.Unmarshal(content, &parts); err != nil {
+		return ""
+	}
+	var texts []string
+	for _, part := range parts {
+		var s string
+		if err := json.Unmarshal(part, &s); err == nil {
+			texts = append(texts, s)
+			continue
+		}
+		var object struct {
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(part, &object); err == nil && object.Text != "" {
+			texts = append(texts, object.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+func importableRole(role string) bool {
+	switch role {
+	case "system", "user", "assistant":
+		return true
+	default:
+		return false
+	}
+}
+
+// importedMessage is a message in OpenAI's format, with the optional fields
+// of aichat's JSON export.
+type importedMessage struct {
+	ID       string          `json:"id"`
+	ParentID string          `json:"parent_id"`
+	Role     string          `json:"role"`
+	Content  json.RawMessage `json:"content"`
+	Time     time.Time       `json:"time"`
+	// Metadata is set in aichat's own export.
+	Metadata *MessageMetadata `json:"metadata"`
+}
+
+type importedConversation struct {
+	ID         string            `json:"id"`
+	Title      string            `json:"title"`
+	Model      string            `json:"model"`
+	CreatedAt  time.Time         `json:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+	ActiveLeaf string            `json:"active_leaf"`
+	Messages   []importedMessage `json:"messages"`
+	Tags       []string          `json:"tags,omitempty"`
+	Pinned     bool              `json:"pinned,omitempty"`
+	Archived   bool              `json:"archived,omitempty"`
+}
+
+func importGeneric(imported importedConversation) (*Conversation, error) {
+	conv := &Conversation{
+		ID:         importedID(imported.ID),
+		Title:      imported.Title,
+		Model:      imported.Model,
+		CreatedAt:  imported.CreatedAt,
+		UpdatedAt:  imported.UpdatedAt,
+		ActiveLeaf: imported.ActiveLeaf,
+		Messages:   []ChatMessage{},
+		Tags:       imported.Tags,
+		Pinned:     imported.Pinned,
+		Archived:   imported.Archived,
+	}
+	// a tree exported by aichat keeps its IDs; a list becomes a single branch
+	tree := len(imported.Messages) > 0
+	for _, msg := range imported.Messages {
+		tree = tree && msg.ID != ""
+	}
+	// the parents of the messages left out, to attach their children above them
+	skipped := map[string]string{}
+	for _, msg := range imported.Messages {
+		text := messageText(msg.Content)
+		if !importableRole(msg.Role) || text == "" {
+			skipped[msg.ID] = msg.ParentID
+			continue
+		}
+		if tree {
+			conv.Messages = append(conv.Messages, ChatMessage{
+				ID: msg.ID, ParentID: msg.ParentID, Role: msg.Role, Content: text, Time: msg.Time, Metadata: msg.Metadata,
+			})
+		} else {
+			conv.AddMessage(msg.Role, text)
+			conv.Messages[len(conv.Messages)-1].Time = msg.Time
+		}
+	}
+	// AddMessage stamps the conversation with the current time
+	conv.UpdatedAt = imported.UpdatedAt
+	if tree {
+		if err := linkImportedTree(conv, skipped); err != nil {
+			return nil, err
+		}
+	}
+	if conv.ID == "" {
+		// derived from the messages only, as they have no other identity
+		key, err := json.Marshal(mapSlice(conv.Messages, func(m ChatMessage) [2]string { return [2]string{m.Role, m.Content} }))
+		if err != nil {
+			return nil, err
+		}
+		conv.ID = uuid.NewSHA1(importNamespace, key).String()
+	}
+	fillImportedTimes(conv)
+	return conv, nil
+}
+
+// linkImportedTree attaches the children of the messages left out of the
+// import to their nearest kept ancestor, and the ones whose parent is
+// missing to the root. It fails when the parents form a cycle.
+func linkImportedTree(conv *Conversation, skipped map[string]string) error {
+	kept := map[string]string{}
+	for _, msg := range conv.Messages {
+		kept[msg.ID] = msg.ParentID
+	}
+	resolve := func(id string) string {
+		for seen := map[string]bool{}; id != "" && !seen[id]; id = skipped[id] {
+			if _, ok := kept[id]; ok {
+				return id
+			}
+			seen[id] = true
+		}
+		return ""
+	}
+	for i := range conv.Messages {
+		conv.Messages[i].ParentID = resolve(conv.Messages[i].ParentID)
+		kept[conv.Messages[i].ID] = conv.Messages[i].ParentID
+	}
+	for id := range kept {
+		for seen := map[string]bool{}; id != ""; id = kept[id] {
+			if seen[id] {
+				return fmt.Errorf("the parents of message %s form a cycle", id)
+			}
+			seen[id] = true
+		}
+	}
+	conv.ActiveLeaf = resolve(conv.ActiveLeaf)
+	if conv.ActiveLeaf == "" && len(conv.Messages) > 0 {
+		conv.ActiveLeaf = conv.Messages[len(conv.Messages)-1].ID
+	}
+	return nil
+}
+
+// importedID returns the ID of an imported conversation, or, when it is not
+// safe to use as one, an ID derived from it.
+func importedID(id string) string {
+	if id == "" || validConversationID(id) {
+		return id
+	}
+	return uuid.NewSHA1(importNamespace, []byte(id)).String()
+}
+
+// fillImportedTimes sets the times that the import didn't give.
+func fillImportedTimes(conv *Conversation) {
+	if conv.CreatedAt.IsZero() {
+		for _, msg := range conv.Messages {
+			if !msg.Time.IsZero() && (conv.CreatedAt.IsZero() || msg.Time.Before(conv.CreatedAt)) {
+				conv.CreatedAt = msg.Time
+			}
+		}
+	}
+	if conv.CreatedAt.IsZero() {
+		conv.CreatedAt = time.Now()
+	}
+	if conv.UpdatedAt.IsZero() {
+		conv.UpdatedAt = conv.CreatedAt
+		for _, msg := range conv.Messages {
+			if msg.Time.After(conv.UpdatedAt) {
+				conv.UpdatedAt = msg.Time
+			}
+		}
+	}
+	for i := range conv.Messages {
+		if conv.Messages[i].Time.IsZero() {
+			conv.Messages[i].Time = conv.CreatedAt
+		}
+	}
+	if conv.Title == "" {
+		conv.Title = GetConversationTitle(conv.ToGPTMessages())
+	}
+}
+
+// chatGPTConversation is a conversation of ChatGPT's data export. Its
+// messages are the nodes of a tree in mapping, and current_node is the leaf
+// of the branch that was shown last.
+type chatGPTConversation struct {
+	ID               string                 `json:"id"`
+	ConversationID   string                 `json:"conversation_id"`
+	Title            string                 `json:"title"`
+	CreateTime       float64                `json:"create_time"`
+	UpdateTime       float64                `json:"update_time"`
+	Mapping          map[string]chatGPTNode `json:"mapping"`
+	CurrentNode      string                 `json:"current_node"`
+	DefaultModelSlug string                 `json:"default_model_slug"`
+}
+
+type chatGPTNode struct {
+	Message  *chatGPTMessage `json:"message"`
+	Parent   string          `json:"parent"`
+	Children []string        `json:"children"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime *float64 `json:"create_time"`
+	Content    struct {
+		Parts json.RawMessage `json:"parts"`
+		Text  string          `json:"text"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+		Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+func unixTime(seconds float64) time.Time {
+	if seconds == 0 {
+		return time.Time{}
+	}
+	whole, frac := math.Modf(seconds)
+	return time.Unix(int64(whole), int64(frac*1e9))
+}
+
+// importChatGPT converts the node tree, leaving out the nodes that aren't
+// visible messages, such as the empty root and tool calls, and attaching
+// their children to the nearest message above them.
+func importChatGPT(imported chatGPTConversation) *Conversation {
+	conv := &Conversation{
+		ID:        imported.ConversationID,
+		Title:     imported.Title,
+		Model:     imported.DefaultModelSlug,
+		CreatedAt: unixTime(imported.CreateTime),
+		UpdatedAt: unixTime(imported.UpdateTime),
+		Messages:  []ChatMessage{},
+	}
+	if conv.ID == "" {
+		conv.ID = imported.ID
+	}
+	conv.ID = importedID(conv.ID)
+
+	ids := map[string]string{}
+	visited := map[string]bool{}
+	var walk func(nodeID, parentID string)
+	walk = func(nodeID, parentID string) {
+		node, ok := imported.Mapping[nodeID]
+		if !ok || visited[nodeID] {
+			return
+		}
+		visited[nodeID] = true
+		if msg := node.Message; msg != nil && importableRole(msg.Author.Role) && !msg.Metadata.Hidden {
+			text := messageText(msg.Content.Parts)
+			if text == "" {
+				text = msg.Content.Text
+			}
+			if strings.TrimSpace(text) != "" {
+				id := strconv.Itoa(len(conv.Messages) + 1)
+				var created time.Time
+				if msg.CreateTime != nil {
+					created = unixTime(*msg.CreateTime)
+				}
+				conv.Messages = append(conv.Messages, ChatMessage{
+					ID: id, ParentID: parentID, Role: msg.Author.Role, Content: text, Time: created,
+				})
+				if msg.Metadata.ModelSlug != "" && imported.DefaultModelSlug == "" {
+					conv.Model = msg.Metadata.ModelSlug
+				}
+				ids[nodeID] = id
+				parentID = id
+			}
+		}
+		for _, child := range node.Children {
+			walk(child, parentID)
+		}
+	}
+	var roots []string
+	for id, node := range imported.Mapping {
+		if _, ok := imported.Mapping[node.Parent]; !ok {
+			roots = append(roots, id)
+		}
+	}
+	sort.Strings(roots)
+	for _, root := range roots {
+		walk(root, "")
+	}
+
+	nodeID := imported.CurrentNode
+	for range imported.Mapping {
+		if conv.ActiveLeaf = ids[nodeID]; conv.ActiveLeaf != "" {
+			break
+		}
+		nodeID = imported.Mapping[nodeID].Parent
+	}
+	if conv.ActiveLeaf == "" && len(conv.Messages) > 0 {
+		conv.ActiveLeaf = conv.Messages[len(conv.Messages)-1].ID
+	}
+	if conv.ID == "" {
+		conv.ID = uuid.NewSHA1(importNamespace, []byte(imported.Title+fmt.Sprint(imported.CreateTime))).String()
+	}
+	fillImportedTimes(conv)
+	return conv
+}
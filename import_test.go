@@ -0,0 +1,182 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chatGPTExport is a trimmed conversations.json from ChatGPT's data export,
+// with a hidden system message, an edited question and a tool call.
+const chatGPTExport = `[{
+  "title": "Rust lifetimes",
+  "create_time": 1700000000.5,
+  "update_time": 1700000300.0,
+  "conversation_id": "6f0c3c2e-0000-4000-8000-000000000001",
+  "current_node": "a2",
+  "default_model_slug": "gpt-4",
+  "mapping": {
+    "root": {"id": "root", "message": null, "parent": null, "children": ["sys"]},
+    "sys": {"id": "sys", "parent": "root", "children": ["u1", "u2"],
+      "message": {"author": {"role": "system"}, "create_time": null, "content": {"content_type": "text", "parts": [""]},
+        "metadata": {"is_visually_hidden_from_conversation": true}}},
+    "u1": {"id": "u1", "parent": "sys", "children": ["a1"],
+      "message": {"author": {"role": "user"}, "create_time": 1700000010, "content": {"content_type": "text", "parts": ["What is 'a?"]}}},
+    "a1": {"id": "a1", "parent": "u1", "children": [],
+      "message": {"author": {"role": "assistant"}, "create_time": 1700000020, "content": {"content_type": "text", "parts": ["A lifetime."]}}},
+    "u2": {"id": "u2", "parent": "sys", "children": ["t1"],
+      "message": {"author": {"role": "user"}, "create_time": 1700000100, "content": {"content_type": "multimodal_text", "parts": [{"content_type": "image_asset_pointer"}, "Explain 'a"]}}},
+    "t1": {"id": "t1", "parent": "u2", "children": ["a2"],
+      "message": {"author": {"role": "tool"}, "create_time": 1700000110, "content": {"content_type": "text", "parts": ["search results"]}}},
+    "a2": {"id": "a2", "parent": "t1", "children": [],
+      "message": {"author": {"role": "assistant"}, "create_time": 1700000120, "content": {"content_type": "text", "parts": ["'a names a lifetime."]},
+        "metadata": {"model_slug": "gpt-4"}}}
+  }
+}, {
+  "title": "Empty", "create_time": 1700000000, "conversation_id": "6f0c3c2e-0000-4000-8000-000000000002",
+  "mapping": {"root": {"id": "root", "message": null, "parent": null, "children": []}}
+}]`
+
+func TestImportChatGPT(t *testing.T) {
+	conversations, empty, err := parseImport([]byte(chatGPTExport))
+	if err != nil {
+		t.Fatalf("parseImport failed: %v", err)
+	}
+	if len(conversations) != 1 || empty != 1 {
+		t.Fatalf("Expected one conversation and one empty, got %d and %d", len(conversations), empty)
+	}
+	conv := conversations[0]
+	if conv.ID != "6f0c3c2e-0000-4000-8000-000000000001" || conv.Title != "Rust lifetimes" || conv.Model != "gpt-4" {
+		t.Errorf("Unexpected conversation: %+v", conv)
+	}
+	if !conv.CreatedAt.Equal(time.Unix(1700000000, 5e8)) || !conv.UpdatedAt.Equal(time.Unix(1700000300, 0)) {
+		t.Errorf("Expected the original timestamps, got %v and %v", conv.CreatedAt, conv.UpdatedAt)
+	}
+	if got := messageContents(conv); len(got) != 2 || got[0] != "Explain 'a" || got[1] != "'a names a lifetime." {
+		t.Errorf("Expected the current branch without hidden and tool messages, got %q", got)
+	}
+	if len(conv.Messages) != 4 || len(conv.Children("")) != 2 {
+		t.Errorf("Expected the edited question to be kept as an alternative, got %+v", conv.Messages)
+	}
+	if !conv.Path()[0].Time.Equal(time.Unix(1700000100, 0)) {
+		t.Errorf("Expected the message time to be kept, got %v", conv.Path()[0].Time)
+	}
+}
+
+func TestImportGeneric(t *testing.T) {
+	tests := []struct {
+		name, input string
+		count       int
+	}{
+		{"messages", `[{"role": "user", "content": "Hi"}, {"role": "assistant", "content": [{"type": "text", "text": "Hello"}]}]`, 1},
+		{"object", `{"title": "Named", "messages": [{"role": "user", "content": "Hi"}]}`, 1},
+		{"jsonl", "{\"messages\": [{\"role\": \"user\", \"content\": \"One\"}]}\n{\"messages\": [{\"role\": \"user\", \"content\": \"Two\"}]}\n", 2},
+	}
+	for _, test := range tests {
+		conversations, _, err := parseImport([]byte(test.input))
+		if err != nil {
+			t.Errorf("%s: parseImport failed: %v", test.name, err)
+			continue
+		}
+		if len(conversations) != test.count {
+			t.Errorf("%s: expected %d conversations, got %d", test.name, test.count, len(conversations))
+			continue
+		}
+		for _, conv := range conversations {
+			if conv.ID == "" || conv.Title == "" || conv.CreatedAt.IsZero() || len(conv.Path()) == 0 {
+				t.Errorf("%s: incomplete conversation %+v", test.name, conv)
+			}
+		}
+	}
+
+	conversations, _, _ := parseImport([]byte(tests[0].input))
+	again, _, _ := parseImport([]byte(tests[0].input))
+	if conversations[0].ID != again[0].ID {
+		t.Error("Expected the same messages to get the same ID")
+	}
+	if got := messageContents(conversations[0]); len(got) != 2 || got[1] != "Hello" {
+		t.Errorf("Expected the text parts to be read, got %q", got)
+	}
+
+	unsafe, _, err := parseImport([]byte(`{"id": "../../x", "messages": [{"role": "user", "content": "Hi"}]}`))
+	if err != nil || len(unsafe) != 1 || !validConversationID(unsafe[0].ID) {
+		t.Errorf("Expected an unsafe ID to be replaced, got %+v, %v", unsafe, err)
+	}
+
+	// the children of a message left out are attached above it
+	gap, _, err := parseImport([]byte(`{"active_leaf": "3", "messages": [
+		{"id": "1", "role": "user", "content": "Question"},
+		{"id": "2", "parent_id": "1", "role": "tool", "content": "result"},
+		{"id": "3", "parent_id": "2", "role": "assistant", "content": "Answer"}]}`))
+	if err != nil || len(gap) != 1 {
+		t.Fatalf("Failed to import a tree with a gap: %v", err)
+	}
+	if got := messageContents(gap[0]); len(got) != 2 || got[1] != "Answer" {
+		t.Errorf("Expected the branch to go on past the gap, got %q", got)
+	}
+	if _, _, err := parseImport([]byte(`{"messages": [
+		{"id": "1", "parent_id": "2", "role": "user", "content": "One"},
+		{"id": "2", "parent_id": "1", "role": "assistant", "content": "Two"}]}`)); err == nil {
+		t.Error("Expected a cycle to fail")
+	}
+
+	if _, _, err := parseImport([]byte(`{"foo": 1}`)); err == nil {
+		t.Error("Expected an unrecognized object to fail")
+	}
+	if _, _, err := parseImport([]byte(`[{"role": `)); err == nil {
+		t.Error("Expected invalid JSON to fail")
+	}
+}
+
+func TestImportFile(t *testing.T) {
+	useTestHistory(t)
+
+	path := filepath.Join(t.TempDir(), "conversations.json")
+	writeTestFiles(t, filepath.Dir(path), map[string]string{"conversations.json": chatGPTExport})
+	summary, err := ImportFile(path)
+	if err != nil {
+		t.Fatalf("ImportFile failed: %v", err)
+	}
+	if summary != (importSummary{Imported: 1, Empty: 1}) {
+		t.Errorf("Unexpected summary: %+v", summary)
+	}
+	if summary, _ := ImportFile(path); summary.Imported != 0 || summary.Duplicates != 1 {
+		t.Errorf("Expected the second import to skip the duplicate, got %+v", summary)
+	}
+	if !strings.Contains(summary.String(), "Imported 1 conversations") {
+		t.Errorf("Unexpected summary text: %q", summary)
+	}
+
+	conv, err := LoadConversation("6f0c3c2e-0000-4000-8000-000000000001")
+	if err != nil {
+		t.Fatalf("Expected the conversation to be saved: %v", err)
+	}
+	if !conv.UpdatedAt.Equal(time.Unix(1700000300, 0)) {
+		t.Errorf("Expected the original update time to be saved, got %v", conv.UpdatedAt)
+	}
+}
+
+func TestImportExportedJSON(t *testing.T) {
+	original := testExportConversation()
+	original.AddTags("work")
+	original.Pinned = true
+	var out strings.Builder
+	if err := ExportConversations(&out, []*Conversation{original, NewConversation("Empty", "gpt-4")}, "json"); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	conversations, empty, err := parseImport([]byte(out.String()))
+	if err != nil {
+		t.Fatalf("parseImport failed: %v", err)
+	}
+	if len(conversations) != 1 || empty != 1 {
+		t.Fatalf("Expected one conversation and one empty, got %d and %d", len(conversations), empty)
+	}
+	imported := conversations[0]
+	if imported.ID != original.ID || imported.ActiveLeaf != original.ActiveLeaf || len(imported.Messages) != len(original.Messages) {
+		t.Errorf("Expected the tree to survive the round trip, got %+v", imported)
+	}
+	if !imported.Pinned || !imported.HasTag("work") {
+		t.Errorf("Expected the tags and flags to survive the round trip, got %+v", imported)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// historyIndexVersion is increased whenever ConversationInfo changes, so
+// that old indexes are rebuilt.
+const historyIndexVersion = 1
+
+// historyIndexFile is the name of the metadata index kept with the history.
+const historyIndexFile = "index"
+
+// ConversationInfo is what listings show of a saved conversation, without
+// its messages.
+type ConversationInfo struct {
+	ID        string    `json:"id"`
+	Title     string    `json:"title"`
+	Model     string    `json:"model"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Messages  int       `json:"messages"`
+	Tags      []string  `json:"tags"`
+	Pinned    bool      `json:"pinned"`
+	Archived  bool      `json:"archived"`
+	// Version is the store's version of the conversation when it was indexed.
+	Version string `json:"-"`
+}
+
+func newConversationInfo(conv *Conversation, version string) ConversationInfo {
+	tags := conv.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return ConversationInfo{
+		ID:        conv.ID,
+		Title:     conv.Title,
+		Model:     conv.Model,
+		CreatedAt: conv.CreatedAt,
+		UpdatedAt: conv.UpdatedAt,
+		Messages:  len(conv.Path()),
+		Tags:      tags,
+		Pinned:    conv.Pinned,
+		Archived:  conv.Archived,
+		Version:   version,
+	}
+}
+
+// historyIndex holds the metadata of every saved conversation, so that
+// listing the history does not load each conversation. Saving and deleting
+// keep it current, and listing checks it against the store's versions, so
+// that conversations changed behind its back are read again.
+type historyIndex struct {
+	Version       int
+	Conversations map[string]ConversationInfo
+}
+
+func newHistoryIndex() *historyIndex {
+	return &historyIndex{Version: historyIndexVersion, Conversations: map[string]ConversationInfo{}}
+}
+
+// loadHistoryIndex reads the index, or returns an empty one when it is
+// missing, unreadable or from another version.
+func loadHistoryIndex(path string) *historyIndex {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return newHistoryIndex()
+	}
+	index := &historyIndex{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(index); err != nil || index.Version != historyIndexVersion {
+		return newHistoryIndex()
+	}
+	if index.Conversations == nil {
+		index.Conversations = map[string]ConversationInfo{}
+	}
+	return index
+}
+
+func (ix *historyIndex) save(path string) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ix); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0600)
+}
+
+// update reads again the conversations of the store that changed since they
+// were indexed, taking the ones in known as they are, and drops the deleted
+// ones. It reports whether the index changed.
+func (ix *historyIndex) update(store HistoryStore, known ...*Conversation) (bool, error) {
+	versions, err := store.Versions()
+	if err != nil {
+		return false, err
+	}
+	fresh := map[string]*Conversation{}
+	for _, conv := range known {
+		fresh[conv.ID] = conv
+	}
+	changed := false
+	for id, version := range versions {
+		conv := fresh[id]
+		if info, ok := ix.Conversations[id]; ok && info.Version == version && conv == nil {
+			continue
+		}
+		if conv == nil {
+			if conv, err = store.Load(id); err != nil {
+				if _, ok := ix.Conversations[id]; ok {
+					delete(ix.Conversations, id)
+					changed = true
+				}
+				continue // Skip conversations that can't be loaded
+			}
+		}
+		ix.Conversations[id] = newConversationInfo(conv, version)
+		changed = true
+	}
+	for id := range ix.Conversations {
+		if _, ok := versions[id]; !ok {
+			delete(ix.Conversations, id)
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+// ListConversationInfos returns the metadata of the saved conversations,
+// most recently updated first.
+func ListConversationInfos() ([]ConversationInfo, error) {
+	path, err := historyStore.DataPath(historyIndexFile)
+	if err != nil {
+		return nil, err
+	}
+	index := loadHistoryIndex(path)
+	changed, err := index.update(historyStore)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		// the index only saves work; listing goes on without it
+		_ = withHistoryLock(func() error {
+			return index.save(path)
+		})
+	}
+	infos := make([]ConversationInfo, 0, len(index.Conversations))
+	for _, info := range index.Conversations {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].UpdatedAt.Equal(infos[j].UpdatedAt) {
+			return infos[i].UpdatedAt.After(infos[j].UpdatedAt)
+		}
+		return infos[i].ID < infos[j].ID
+	})
+	return infos, nil
+}
+
+// indexConversations brings the index up to date after conversations were
+// saved or deleted, without reading back the saved ones.
+func indexConversations(saved ...*Conversation) {
+	path, err := historyStore.DataPath(historyIndexFile)
+	if err == nil {
+		index := loadHistoryIndex(path)
+		var changed bool
+		if changed, err = index.update(historyStore, saved...); err == nil && changed {
+			err = index.save(path)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to update the history index: %v", err)
+	}
+}
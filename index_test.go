@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHistoryIndex(t *testing.T) {
+	tempDir := useTestHistory(t)
+	indexPath := filepath.Join(tempDir, "."+historyIndexFile)
+
+	conv := NewConversation("Indexed", "gpt-4")
+	conv.AddMessage("user", "Hello")
+	conv.AddTags("work")
+	if err := SaveConversation(conv); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	versions, _ := historyStore.Versions()
+	info, ok := loadHistoryIndex(indexPath).Conversations[conv.ID]
+	if !ok || info.Title != "Indexed" || info.Messages != 1 || info.Tags[0] != "work" || info.Version != versions[conv.ID] {
+		t.Errorf("Expected saving to index the conversation, got %+v", info)
+	}
+
+	// a conversation written behind the index's back is read when listing
+	other := NewConversation("Copied in", "gpt-4")
+	data, err := encodeConversation(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, other.ID+".yml"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := ListConversationInfos()
+	if err != nil {
+		t.Fatalf("ListConversationInfos failed: %v", err)
+	}
+	if len(infos) != 2 {
+		t.Errorf("Expected the new file to be indexed, got %+v", infos)
+	}
+
+	if err := os.Remove(filepath.Join(tempDir, other.ID+".yml")); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := ListConversationInfos(); len(infos) != 1 || infos[0].ID != conv.ID {
+		t.Errorf("Expected the removed file to be dropped, got %+v", infos)
+	}
+
+	// a conversation that can no longer be read is dropped from the saved index
+	if err := SaveConversation(other); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, other.ID+".yml"), []byte("messages: [unterminated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := ListConversationInfos(); len(infos) != 1 {
+		t.Errorf("Expected the damaged file to be skipped, got %+v", infos)
+	}
+	if _, ok := loadHistoryIndex(indexPath).Conversations[other.ID]; ok {
+		t.Error("Expected the damaged file to be dropped from the saved index")
+	}
+
+	if err := DeleteConversation(conv.ID); err != nil {
+		t.Fatalf("Failed to delete conversation: %v", err)
+	}
+	if _, ok := loadHistoryIndex(indexPath).Conversations[conv.ID]; ok {
+		t.Error("Expected deleting to remove the conversation from the index")
+	}
+
+	// a damaged index is rebuilt
+	if err := SaveConversation(conv); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	if err := os.WriteFile(indexPath, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := ListConversationInfos(); len(infos) != 1 || infos[0].Title != "Indexed" {
+		t.Errorf("Expected the index to be rebuilt, got %+v", infos)
+	}
+}
+
+func TestHistoryIndexSQLite(t *testing.T) {
+	store, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("Failed to open the SQLite store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	useTestStore(t, store)
+
+	conv := NewConversation("Indexed", "gpt-4")
+	conv.AddMessage("user", "Hello")
+	conv.AddTags("aaaa")
+	if err := historyStore.Save(conv); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	if infos, _ := ListConversationInfos(); len(infos) != 1 || infos[0].Tags[0] != "aaaa" {
+		t.Fatalf("Expected the conversation to be indexed, got %+v", infos)
+	}
+
+	// another session swaps the tag without changing the time or the size
+	conv.Tags = []string{"bbbb"}
+	if err := historyStore.Save(conv); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	if infos, _ := ListConversationInfos(); len(infos) != 1 || infos[0].Tags[0] != "bbbb" {
+		t.Errorf("Expected the changed tag to be indexed, got %+v", infos)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/chzyer/readline"
+)
+
+// multilineDelimiter opens and closes a message that spans several lines.
+const multilineDelimiter = `"""`
+
+// errInterrupt is returned by ReadLine when the user presses Ctrl-C.
+var errInterrupt = errors.New("interrupted")
+
+// lineReader reads user input in the chat loop one line at a time.
+// ReadLine returns io.EOF when there is no more input and errInterrupt when
+// the user abandons the line.
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+	Close() error
+}
+
+// scannerLineReader reads lines from a non-interactive stream such as a pipe.
+type scannerLineReader struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+func newScannerLineReader(in io.Reader, out io.Writer) *scannerLineReader {
+	return &scannerLineReader{scanner: bufio.NewScanner(in), out: out}
+}
+
+func (r *scannerLineReader) ReadLine(prompt string) (string, error) {
+	if _, err := fmt.Fprint(r.out, prompt); err != nil {
+		return "", err
+	}
+	if !r.scanner.Scan() {
+		if err := r.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return r.scanner.Text(), nil
+}
+
+func (r *scannerLineReader) Close() error {
+	return nil
+}
+
+// errOpenEditor is returned by ReadLine, together with the line typed so far,
+// when the user presses Ctrl-X Ctrl-E to compose the message in an editor.
+var errOpenEditor = errors.New("open editor")
+
+const charCtrlX = 24
+
+// readlineLineReader provides line editing, history recall and search on a terminal.
+type readlineLineReader struct {
+	instance *readline.Instance
+	// ctrlX and openEditor are touched by the readline input goroutine.
+	ctrlX      atomic.Bool
+	openEditor atomic.Bool
+}
+
+func newReadlineLineReader(historyFile string, autoComplete readline.AutoCompleter) (*readlineLineReader, error) {
+	r := &readlineLineReader{}
+	instance, err := readline.NewEx(&readline.Config{
+		HistoryFile:         historyFile,
+		HistorySearchFold:   true,
+		AutoComplete:        autoComplete,
+		FuncFilterInputRune: r.filterInputRune,
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.instance = instance
+	return r, nil
+}
+
+// filterInputRune turns Ctrl-X Ctrl-E into a submit that requests the editor.
+func (r *readlineLineReader) filterInputRune(c rune) (rune, bool) {
+	if c == charCtrlX {
+		r.ctrlX.Store(true)
+		return c, false
+	}
+	if r.ctrlX.Swap(false) && c == readline.CharLineEnd {
+		r.openEditor.Store(true)
+		return readline.CharEnter, true
+	}
+	return c, true
+}
+
+func (r *readlineLineReader) ReadLine(prompt string) (string, error) {
+	r.instance.SetPrompt(prompt)
+	line, err := r.instance.Readline()
+	if errors.Is(err, readline.ErrInterrupt) {
+		return "", errInterrupt
+	}
+	if err == nil && r.openEditor.Swap(false) {
+		return line, errOpenEditor
+	}
+	return line, err
+}
+
+func (r *readlineLineReader) Close() error {
+	return r.instance.Close()
+}
+
+type GetInputHistoryFileFunc func() (string, error)
+
+// GetInputHistoryFile returns the file that keeps lines typed at the chat prompt.
+// It is separate from the conversation history directory.
+var GetInputHistoryFile GetInputHistoryFileFunc = func() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(homeDir, ".aichat")
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, "input_history"), nil
+}
+
+// newLineReader uses line editing and tab completion when stdin is a terminal
+// and falls back to a plain scanner otherwise.
+func newLineReader(autoComplete readline.AutoCompleter) (lineReader, error) {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return newScannerLineReader(os.Stdin, os.Stdout), nil
+	}
+	historyFile, err := GetInputHistoryFile()
+	if err != nil {
+		return nil, err
+	}
+	return newReadlineLineReader(historyFile, autoComplete)
+}
+
+// readMessage reads one message from reader. A line starting with """ opens a
+// block that continues until a line ending with """, so that pasted code or
+// several paragraphs are sent as a single message. Ctrl-C in a block discards
+// the whole block with errInterrupt. It also reports whether the message was
+// a block rather than a single line.
+func readMessage(reader lineReader, prompt string) (string, bool, error) {
+	line, err := reader.ReadLine(prompt)
+	if errors.Is(err, errOpenEditor) {
+		return line, false, err
+	}
+	if err != nil {
+		return "", false, err
+	}
+	input := strings.TrimSpace(line)
+	if !strings.HasPrefix(input, multilineDelimiter) {
+		return input, false, nil
+	}
+
+	first := strings.TrimPrefix(input, multilineDelimiter)
+	if strings.HasSuffix(first, multilineDelimiter) {
+		return strings.TrimSpace(strings.TrimSuffix(first, multilineDelimiter)), true, nil
+	}
+	var lines []string
+	if first != "" {
+		lines = append(lines, first)
+	}
+	for {
+		line, err := reader.ReadLine("... ")
+		if errors.Is(err, io.EOF) {
+			// an unterminated block at the end of input is sent as is; the
+			// next read reports EOF
+			break
+		}
+		if err != nil {
+			return "", true, err
+		}
+		trimmed := strings.TrimRight(line, " \t")
+		if strings.HasSuffix(trimmed, multilineDelimiter) {
+			lines = append(lines, strings.TrimSuffix(trimmed, multilineDelimiter))
+			break
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")), true, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestScannerLineReader(t *testing.T) {
+	var out strings.Builder
+	reader := newScannerLineReader(strings.NewReader("hello\nworld\n"), &out)
+
+	for _, expected := range []string{"hello", "world"} {
+		line, err := reader.ReadLine("user: ")
+		if err != nil {
+			t.Fatalf("ReadLine() returned an error: %v", err)
+		}
+		if line != expected {
+			t.Errorf("Expected %q, got %q", expected, line)
+		}
+	}
+
+	if _, err := reader.ReadLine("user: "); !errors.Is(err, io.EOF) {
+		t.Errorf("Expected io.EOF at end of input, got %v", err)
+	}
+
+	if out.String() != "user: user: user: " {
+		t.Errorf("Expected a prompt per read, got %q", out.String())
+	}
+}
+
+// scriptedLineReader returns the lines, then the error, then io.EOF.
+type scriptedLineReader struct {
+	lines []string
+	err   error
+}
+
+func (r *scriptedLineReader) ReadLine(prompt string) (string, error) {
+	if len(r.lines) > 0 {
+		line := r.lines[0]
+		r.lines = r.lines[1:]
+		return line, nil
+	}
+	err := r.err
+	r.err = io.EOF
+	return "", err
+}
+
+func (r *scriptedLineReader) Close() error {
+	return nil
+}
+
+func TestReadMessageInterrupt(t *testing.T) {
+	reader := &scriptedLineReader{lines: []string{`"""`, "half a paste"}, err: errInterrupt}
+	if message, _, err := readMessage(reader, "user: "); !errors.Is(err, errInterrupt) || message != "" {
+		t.Errorf("Expected Ctrl-C to discard the block, got %q, %v", message, err)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+		// blocks are the expected messages that were blocks
+		blocks []bool
+	}{
+		{"single line", "  hello  \n", []string{"hello"}, []bool{false}},
+		{"empty line", "\nhello\n", []string{"", "hello"}, []bool{false, false}},
+		{"block", "\"\"\"\nfunc main() {\n\tprintln()\n}\n\"\"\"\nnext\n", []string{"func main() {\n\tprintln()\n}", "next"}, []bool{true, false}},
+		{"block with text on delimiter lines", "\"\"\"first\nsecond\nthird\"\"\"\n", []string{"first\nsecond\nthird"}, []bool{true}},
+		{"inline block", "\"\"\"hello\"\"\"\n", []string{"hello"}, []bool{true}},
+		{"unterminated block", "\"\"\"\nfirst\n\nsecond\n", []string{"first\n\nsecond"}, []bool{true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := newScannerLineReader(strings.NewReader(test.input), io.Discard)
+			for i, expected := range test.expected {
+				message, block, err := readMessage(reader, "user: ")
+				if err != nil {
+					t.Fatalf("readMessage() returned an error: %v", err)
+				}
+				if message != expected || block != test.blocks[i] {
+					t.Errorf("Expected %q (block %v), got %q (block %v)", expected, test.blocks[i], message, block)
+				}
+			}
+			if _, _, err := readMessage(reader, "user: "); !errors.Is(err, io.EOF) {
+				t.Errorf("Expected io.EOF at end of input, got %v", err)
+			}
+		})
+	}
+}
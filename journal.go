@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// journalRetention is how long a journal is kept after its last entry.
+const journalRetention = 30 * 24 * time.Hour
+
+type GetJournalDirFunc func() (string, error)
+
+var GetJournalDir GetJournalDirFunc = func() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	journalDir := filepath.Join(homeDir, ".aichat", "journal")
+
+	if err := os.MkdirAll(journalDir, 0700); err != nil {
+		return "", err
+	}
+
+	return journalDir, nil
+}
+
+// journalEntry is a line of a journal: a message, with the conversation as
+// it was when the message was written.
+type journalEntry struct {
+	Time           time.Time       `json:"time"`
+	ConversationID string          `json:"conversation_id"`
+	Title          string          `json:"title"`
+	Model          string          `json:"model"`
+	ActiveLeaf     string          `json:"active_leaf"`
+	Message        exportedMessage `json:"message"`
+}
+
+// sessionJournal tracks the messages of the current conversation that are
+// in its journal.
+type sessionJournal struct {
+	conversationID string
+	written        map[string]bool
+}
+
+// writeJournal appends the messages of the conversation that are not yet in
+// its journal, one JSON line each, to ~/.aichat/journal/<ID>.jsonl. The
+// journal is written whether or not the conversation is saved, so that
+// RecoverJournal can bring back a session lost to a crash or a failed save.
+func (aiChat *AIChat) writeJournal() {
+	conv := aiChat.conversation
+	journal := &aiChat.journal
+	if journal.conversationID != conv.ID {
+		// messages of a loaded conversation are in the history already
+		journal.conversationID = conv.ID
+		journal.written = map[string]bool{}
+		for _, msg := range conv.Messages {
+			if !msg.Time.After(conv.savedAt) {
+				journal.written[msg.ID] = true
+			}
+		}
+		if err := pruneJournals(); err != nil && aiChat.options.verbose {
+			log.Printf("Failed to prune journals: %v", err)
+		}
+	}
+	var messages []ChatMessage
+	for _, msg := range conv.Messages {
+		if !journal.written[msg.ID] {
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+	if err := appendJournal(conv, messages); err != nil {
+		log.Printf("Failed to write the journal: %v", err)
+		return
+	}
+	for _, msg := range messages {
+		journal.written[msg.ID] = true
+	}
+}
+
+func appendJournal(conv *Conversation, messages []ChatMessage) error {
+	dir, err := GetJournalDir()
+	if err != nil {
+		return err
+	}
+	var lines []byte
+	for _, msg := range messages {
+		line, err := json.Marshal(journalEntry{
+			Time:           time.Now(),
+			ConversationID: conv.ID,
+			Title:          conv.Title,
+			Model:          conv.Model,
+			ActiveLeaf:     conv.ActiveLeaf,
+			Message:        exportedMessage(msg),
+		})
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	file, err := os.OpenFile(filepath.Join(dir, conv.ID+".jsonl"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(lines); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// pruneJournals removes the journals not written to for journalRetention.
+func pruneJournals() error {
+	journals, err := journalFiles()
+	if err != nil {
+		return err
+	}
+	for _, info := range journals {
+		if time.Since(info.ModTime()) > journalRetention {
+			dir, err := GetJournalDir()
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(filepath.Join(dir, info.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// journalFiles returns the journal files by conversation ID.
+func journalFiles() (map[string]os.FileInfo, error) {
+	dir, err := GetJournalDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	journals := map[string]os.FileInfo{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		journals[id] = info
+	}
+	return journals, nil
+}
+
+// readJournal returns the entries of the journal of the conversation. A
+// line cut short by a crash ends the journal.
+func readJournal(id string) ([]journalEntry, error) {
+	dir, err := GetJournalDir()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(dir, id+".jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	var entries []journalEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			break
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// findJournalID returns the ID of the journal whose ID is or starts with query.
+func findJournalID(query string) (string, error) {
+	journals, err := journalFiles()
+	if err != nil {
+		return "", err
+	}
+	if _, ok := journals[query]; ok {
+		return query, nil
+	}
+	var ids []string
+	for id := range journals {
+		if len(query) >= minIDPrefix && strings.HasPrefix(id, query) {
+			ids = append(ids, id)
+		}
+	}
+	switch len(ids) {
+	case 0:
+		return "", fmt.Errorf("no journal for %q", query)
+	case 1:
+		return ids[0], nil
+	default:
+		sort.Strings(ids)
+		return "", fmt.Errorf("%q matches %d journals: %s", query, len(ids), strings.Join(ids, ", "))
+	}
+}
+
+// RecoverJournal saves the conversation recorded in a journal, adding the
+// journaled messages missing from the saved copy, if there is one. It
+// returns the conversation and the number of messages added.
+func RecoverJournal(query string) (*Conversation, int, error) {
+	id, err := findJournalID(query)
+	if err != nil {
+		return nil, 0, err
+	}
+	entries, err := readJournal(id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(entries) == 0 {
+		return nil, 0, fmt.Errorf("the journal of %s is empty", id)
+	}
+	conv, err := LoadConversation(id)
+	if errors.Is(err, fs.ErrNotExist) {
+		conv = &Conversation{ID: id, Messages: []ChatMessage{}, CreatedAt: entries[0].Message.Time}
+	} else if err != nil {
+		return nil, 0, err
+	}
+	added := 0
+	for _, entry := range entries {
+		if conv.Message(entry.Message.ID) == nil {
+			conv.Messages = append(conv.Messages, ChatMessage(entry.Message))
+			added++
+		}
+		conv.Title = entry.Title
+		conv.Model = entry.Model
+		if conv.Message(entry.ActiveLeaf) != nil {
+			conv.ActiveLeaf = entry.ActiveLeaf
+		}
+	}
+	if added == 0 {
+		return conv, 0, nil
+	}
+	return conv, added, SaveConversation(conv)
+}
+
+// printJournals lists the journals, most recently written first.
+func printJournals(w io.Writer) error {
+	journals, err := journalFiles()
+	if err != nil {
+		return err
+	}
+	if len(journals) == 0 {
+		_, err := fmt.Fprintln(w, "No journals.")
+		return err
+	}
+	ids := sortedKeys(journals)
+	sort.SliceStable(ids, func(i, j int) bool {
+		return journals[ids[i]].ModTime().After(journals[ids[j]].ModTime())
+	})
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tWRITTEN\tMESSAGES\tSAVED\tTITLE")
+	for _, id := range ids {
+		entries, err := readJournal(id)
+		if err != nil || len(entries) == 0 {
+			continue
+		}
+		saved := "no"
+		if conv, err := LoadConversation(id); err == nil {
+			saved = "yes"
+			for _, entry := range entries {
+				if conv.Message(entry.Message.ID) == nil {
+					saved = "partly"
+					break
+				}
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", id, journals[id].ModTime().Local().Format("2006-01-02 15:04"),
+			len(entries), saved, entries[len(entries)-1].Title)
+	}
+	return tw.Flush()
+}
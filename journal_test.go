@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func TestJournal(t *testing.T) {
+	useTestHistory(t)
+
+	aiChat, _ := newTestAIChat(t, "Hi there!", "Fine.")
+	if err := aiChat.singleTurn("Hello", &strings.Builder{}); err != nil {
+		t.Fatalf("singleTurn failed: %v", err)
+	}
+	id := aiChat.conversation.ID
+
+	// a loaded conversation only journals the new messages
+	loaded, err := LoadConversation(id)
+	if err != nil {
+		t.Fatalf("LoadConversation failed: %v", err)
+	}
+	aiChat.setConversation(loaded)
+	aiChat.journal = sessionJournal{}
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "How are you?")
+	if err := aiChat.generateReply(0.5, &strings.Builder{}); err != nil {
+		t.Fatalf("generateReply failed: %v", err)
+	}
+	entries, err := readJournal(id)
+	if err != nil {
+		t.Fatalf("readJournal failed: %v", err)
+	}
+	contents := mapSlice(entries, func(e journalEntry) string { return e.Message.Content })
+	if strings.Join(contents, "|") != "Hello|Hi there!|How are you?|Fine." {
+		t.Errorf("Expected each message once, got %q", contents)
+	}
+
+	// the last turn was never saved
+	conv, added, err := RecoverJournal(id[:8])
+	if err != nil {
+		t.Fatalf("RecoverJournal failed: %v", err)
+	}
+	if added != 2 || len(conv.Path()) != 4 {
+		t.Errorf("Expected the unsaved turn to be recovered, got %d messages added to %q", added, messageContents(conv))
+	}
+	if stored, _ := LoadConversation(id); len(stored.Path()) != 4 || stored.Path()[3].Content != "Fine." {
+		t.Errorf("Expected the recovered conversation to be saved, got %+v", stored)
+	}
+	if _, added, _ := RecoverJournal(id); added != 0 {
+		t.Errorf("Expected nothing to recover the second time, got %d", added)
+	}
+
+	var list strings.Builder
+	if err := printJournals(&list); err != nil || !strings.Contains(list.String(), id) {
+		t.Errorf("Expected the journal to be listed, got %q, %v", list.String(), err)
+	}
+}
+
+func TestRecoverUnsavedJournal(t *testing.T) {
+	useTestHistory(t)
+
+	aiChat, _ := newTestAIChat(t, "Hi there!")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := aiChat.generateReply(0.5, &strings.Builder{}); err != nil {
+		t.Fatalf("generateReply failed: %v", err)
+	}
+
+	conv, added, err := RecoverJournal(aiChat.conversation.ID)
+	if err != nil {
+		t.Fatalf("RecoverJournal failed: %v", err)
+	}
+	if added != 2 || conv.Title != "Hello" || conv.ActiveLeaf != aiChat.conversation.ActiveLeaf {
+		t.Errorf("Expected the whole conversation to be recovered, got %+v", conv)
+	}
+	if _, _, err := RecoverJournal("missing"); err == nil {
+		t.Error("Expected a missing journal to fail")
+	}
+}
+
+func TestJournalAfterFailedEdit(t *testing.T) {
+	useTestHistory(t)
+
+	aiChat, _ := newTestAIChat(t, "Hi there!", "Sure.")
+	if err := aiChat.singleTurn("Hello", &strings.Builder{}); err != nil {
+		t.Fatalf("singleTurn failed: %v", err)
+	}
+	client := aiChat.client
+	aiChat.client = failingClient(t)
+	if err := aiChat.editMessage("1 Bonjour"); err == nil {
+		t.Fatal("Expected the edit to fail")
+	}
+	aiChat.client = client
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Later message")
+	if err := aiChat.generateReply(0.5, &strings.Builder{}); err != nil {
+		t.Fatalf("generateReply failed: %v", err)
+	}
+
+	entries, err := readJournal(aiChat.conversation.ID)
+	if err != nil {
+		t.Fatalf("readJournal failed: %v", err)
+	}
+	contents := mapSlice(entries, func(e journalEntry) string { return e.Message.Content })
+	if !strings.Contains(strings.Join(contents, "|"), "Later message|Sure.") {
+		t.Errorf("Expected the message after the failed edit to be journaled, got %q", contents)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// listFormats are the formats of --list-history.
+var listFormats = []string{"table", "json"}
+
+// listQuery selects a page of the history: the conversations passing the
+// filter, skipping Offset of them and showing at most Limit, or all when
+// Limit is 0.
+type listQuery struct {
+	ConversationFilter
+	Limit  int
+	Offset int
+}
+
+// ListHistory returns the page of saved conversations selected by the
+// query, pinned ones first and then the most recently updated, and the
+// number of conversations passing the filter.
+func ListHistory(query listQuery) ([]ConversationInfo, int, error) {
+	if query.Limit < 0 || query.Offset < 0 {
+		return nil, 0, fmt.Errorf("limit and offset must not be negative")
+	}
+	all, err := ListConversationInfos()
+	if err != nil {
+		return nil, 0, err
+	}
+	var matched []ConversationInfo
+	for _, info := range all {
+		if query.Match(info) {
+			matched = append(matched, info)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Pinned && !matched[j].Pinned
+	})
+	page := matched[min(query.Offset, len(matched)):]
+	if query.Limit > 0 && len(page) > query.Limit {
+		page = page[:query.Limit]
+	}
+	return page, len(matched), nil
+}
+
+// parseListQuery parses the arguments of /list: tag:, status:, model:,
+// since: and until: filters, and limit: and offset:. The status defaults to
+// active, hiding archived conversations.
+func parseListQuery(input string) (listQuery, error) {
+	query := listQuery{ConversationFilter: ConversationFilter{Status: statusActive}}
+	for _, word := range strings.Fields(input) {
+		key, value, ok := strings.Cut(word, ":")
+		if !ok {
+			return query, fmt.Errorf("unknown filter %q, use tag:, status:, model:, since:, until:, limit: or offset:", word)
+		}
+		var err error
+		switch key {
+		case "tag":
+			query.Tags = append(query.Tags, value)
+		case "status":
+			query.Status, err = parseStatus(value)
+		case "model":
+			query.Model = value
+		case "since":
+			query.Since, err = parseDate(value, false)
+		case "until":
+			query.Until, err = parseDate(value, true)
+		case "limit":
+			query.Limit, err = strconv.Atoi(value)
+		case "offset":
+			query.Offset, err = strconv.Atoi(value)
+		default:
+			err = fmt.Errorf("unknown filter %q, use tag:, status:, model:, since:, until:, limit: or offset:", word)
+		}
+		if err != nil {
+			return query, err
+		}
+	}
+	return query, nil
+}
+
+// printHistory lists a page of the history as a table, noting when there
+// are more conversations than shown, or as a JSON array.
+func printHistory(w io.Writer, query listQuery, format string) error {
+	infos, total, err := ListHistory(query)
+	if err != nil {
+		return err
+	}
+	if err := printConversationList(w, infos, format); err != nil {
+		return err
+	}
+	if format == "table" && len(infos) > 0 && len(infos) < total {
+		_, err = fmt.Fprintf(w, "Showing %d-%d of %d conversations.\n", query.Offset+1, query.Offset+len(infos), total)
+	}
+	return err
+}
+
+// printConversationList writes the conversations as a table or as a JSON array.
+func printConversationList(w io.Writer, infos []ConversationInfo, format string) error {
+	switch format {
+	case "table":
+		if len(infos) == 0 {
+			_, err := fmt.Fprintln(w, "No saved conversations.")
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUPDATED\tMODEL\tSTATUS\tTAGS\tTITLE")
+		for _, info := range infos {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", info.ID, info.UpdatedAt.Local().Format("2006-01-02 15:04"),
+				info.Model, conversationStatus(info), strings.Join(info.Tags, ","), info.Title)
+		}
+		return tw.Flush()
+	case "json":
+		if infos == nil {
+			infos = []ConversationInfo{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(infos)
+	default:
+		return fmt.Errorf("unknown list format %q, use %s", format, strings.Join(listFormats, " or "))
+	}
+}
+
+func conversationStatus(info ConversationInfo) string {
+	var status []string
+	if info.Pinned {
+		status = append(status, statusPinned)
+	}
+	if info.Archived {
+		status = append(status, statusArchived)
+	}
+	return strings.Join(status, ",")
+}
+
+// UpdateSavedConversation applies change to the saved conversation named by
+// query. The update time is kept, as tags and flags are not activity.
+func UpdateSavedConversation(query string, change func(*Conversation), choose conversationChooser) (*Conversation, error) {
+	id, err := FindConversationID(query, choose)
+	if err != nil {
+		return nil, err
+	}
+	var conv *Conversation
+	err = withHistoryLock(func() error {
+		if conv, err = LoadConversation(id); err != nil {
+			return err
+		}
+		change(conv)
+		if err := historyStore.Save(conv); err != nil {
+			return err
+		}
+		indexConversations(conv)
+		return nil
+	})
+	return conv, err
+}
+
+// updateConversation applies change to the current conversation and, if it
+// was saved before, to the saved copy, so that tags and flags stick without
+// saving messages the user has not saved. When the saved copy is the one the
+// session loaded, the session follows its new revision; otherwise the next
+// save still reports the conflict.
+func (aiChat *AIChat) updateConversation(change func(*Conversation)) error {
+	conv := aiChat.conversation
+	change(conv)
+	return withHistoryLock(func() error {
+		stored, err := LoadConversation(conv.ID)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		current := stored.Revision == conv.Revision
+		change(stored)
+		if err := historyStore.Save(stored); err != nil {
+			return err
+		}
+		if current {
+			conv.Revision = stored.Revision
+		}
+		indexConversations(stored)
+		return nil
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// saveTestConversations saves a conversation for each title, updated an hour
+// apart in the order given, with the change applied to each.
+func saveTestConversations(t *testing.T, titles []string, change func(title string, conv *Conversation)) {
+	useTestHistory(t)
+	updated := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, title := range titles {
+		conv := NewConversation(title, "gpt-4")
+		conv.AddMessage("user", title)
+		conv.UpdatedAt = updated.Add(time.Duration(i) * time.Hour)
+		change(title, conv)
+		if err := historyStore.Save(conv); err != nil {
+			t.Fatalf("Failed to save conversation: %v", err)
+		}
+	}
+}
+
+func TestListHistory(t *testing.T) {
+	saveTestConversations(t, []string{"Pinned", "Old", "Archived", "New"}, func(title string, conv *Conversation) {
+		conv.Pinned = title == "Pinned"
+		conv.Archived = title == "Archived"
+		if title != "New" {
+			conv.AddTags("work")
+		}
+	})
+
+	tests := []struct {
+		filter ConversationFilter
+		titles string
+	}{
+		{ConversationFilter{}, "Pinned New Archived Old"},
+		{ConversationFilter{Status: statusActive}, "Pinned New Old"},
+		{ConversationFilter{Status: statusArchived}, "Archived"},
+		{ConversationFilter{Status: statusPinned}, "Pinned"},
+		{ConversationFilter{Status: statusActive, Tags: []string{"Work"}}, "Pinned Old"},
+		{ConversationFilter{Tags: []string{"work", "home"}}, ""},
+	}
+	for _, test := range tests {
+		conversations, _, err := ListHistory(listQuery{ConversationFilter: test.filter})
+		if err != nil {
+			t.Fatalf("ListHistory failed: %v", err)
+		}
+		titles := strings.Join(mapSlice(conversations, func(info ConversationInfo) string { return info.Title }), " ")
+		if titles != test.titles {
+			t.Errorf("%+v: expected %q, got %q", test.filter, test.titles, titles)
+		}
+	}
+
+	page, total, err := ListHistory(listQuery{Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatalf("ListHistory failed: %v", err)
+	}
+	if total != 4 || len(page) != 2 || page[0].Title != "New" || page[1].Title != "Archived" {
+		t.Errorf("Expected the second and third of 4, got %d of %+v", total, page)
+	}
+	if page, _, _ := ListHistory(listQuery{Offset: 10}); len(page) != 0 {
+		t.Errorf("Expected an offset past the end to list nothing, got %+v", page)
+	}
+	var out strings.Builder
+	if err := printHistory(&out, listQuery{Limit: 1}, "table"); err != nil || !strings.Contains(out.String(), "Showing 1-1 of 4 conversations.") {
+		t.Errorf("Expected a note about the other conversations, got %q, %v", out.String(), err)
+	}
+}
+
+func TestParseListQuery(t *testing.T) {
+	query, err := parseListQuery("tag:work tag:rust status:all model:gpt-4 limit:10 offset:20")
+	if err != nil {
+		t.Fatalf("parseListQuery failed: %v", err)
+	}
+	if len(query.Tags) != 2 || query.Status != statusAll || query.Model != "gpt-4" || query.Limit != 10 || query.Offset != 20 {
+		t.Errorf("Unexpected query: %+v", query)
+	}
+	if filter, _ := parseListQuery(""); filter.Status != statusActive {
+		t.Errorf("Expected archived conversations to be hidden by default, got %+v", filter)
+	}
+	for _, input := range []string{"status:deleted", "work", "color:red", "since:yesterday", "limit:ten"} {
+		if _, err := parseListQuery(input); err == nil {
+			t.Errorf("Expected %q to fail", input)
+		}
+	}
+}
+
+func TestPrintConversationList(t *testing.T) {
+	conv := NewConversation("Tagged", "gpt-4")
+	conv.AddMessage("user", "Hello")
+	conv.AddTags("work", "rust")
+	conv.Pinned = true
+
+	info := newConversationInfo(conv, "")
+	var table strings.Builder
+	if err := printConversationList(&table, []ConversationInfo{info}, "table"); err != nil {
+		t.Fatalf("printConversationList failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID ") || !strings.Contains(lines[1], "pinned") || !strings.Contains(lines[1], "rust,work") {
+		t.Errorf("Unexpected table:\n%s", table.String())
+	}
+
+	var out strings.Builder
+	if err := printConversationList(&out, []ConversationInfo{info}, "json"); err != nil {
+		t.Fatalf("printConversationList failed: %v", err)
+	}
+	var listed []ConversationInfo
+	if err := json.Unmarshal([]byte(out.String()), &listed); err != nil {
+		t.Fatalf("Invalid JSON: %v", err)
+	}
+	if len(listed) != 1 || listed[0].ID != conv.ID || !listed[0].Pinned || len(listed[0].Tags) != 2 || listed[0].Messages != 1 {
+		t.Errorf("Unexpected JSON: %s", out.String())
+	}
+
+	out.Reset()
+	if err := printConversationList(&out, nil, "json"); err != nil || strings.TrimSpace(out.String()) != "[]" {
+		t.Errorf("Expected an empty array, got %q, %v", out.String(), err)
+	}
+	if err := printConversationList(&out, nil, "csv"); err == nil {
+		t.Error("Expected an unknown format to fail")
+	}
+}
+
+func TestUpdateConversation(t *testing.T) {
+	saveTestConversations(t, []string{"Saved"}, func(string, *Conversation) {})
+	conversations, _ := ListConversations()
+	saved := conversations[0]
+	savedAt := saved.UpdatedAt
+
+	aiChat := &AIChat{conversation: saved}
+	saved.AddMessage("assistant", "Not saved yet")
+	if err := aiChat.updateConversation(func(conv *Conversation) { conv.Pinned = true }); err != nil {
+		t.Fatalf("updateConversation failed: %v", err)
+	}
+	stored, err := LoadConversation(saved.ID)
+	if err != nil {
+		t.Fatalf("LoadConversation failed: %v", err)
+	}
+	if !stored.Pinned || len(stored.Messages) != 1 {
+		t.Errorf("Expected only the flag to be saved, got %+v", stored)
+	}
+	if !stored.UpdatedAt.Equal(savedAt) {
+		t.Errorf("Expected the update time to be kept, got %v", stored.UpdatedAt)
+	}
+	if err := SaveConversation(saved); err != nil {
+		t.Errorf("Expected the session to save after its own update, got %v", err)
+	}
+
+	aiChat.conversation = NewConversation("Unsaved", "gpt-4")
+	if err := aiChat.updateConversation(func(conv *Conversation) { conv.AddTags("draft") }); err != nil {
+		t.Fatalf("updateConversation failed: %v", err)
+	}
+	if _, err := LoadConversation(aiChat.conversation.ID); err == nil {
+		t.Error("Expected an unsaved conversation to stay unsaved")
+	}
+
+	if _, err := UpdateSavedConversation("Saved", func(conv *Conversation) { conv.Archived = true }, nil); err != nil {
+		t.Fatalf("UpdateSavedConversation failed: %v", err)
+	}
+	if stored, _ := LoadConversation(saved.ID); !stored.Archived {
+		t.Error("Expected the conversation to be archived")
+	}
+	// a session that loaded the conversation before would undo the flag
+	if err := SaveConversation(saved); !errors.Is(err, errSaveConflict) {
+		t.Errorf("Expected a conflict after an update by another session, got %v", err)
+	}
+}
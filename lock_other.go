@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// lockFile does nothing where flock is not available; concurrent saves are
+// then only caught by the conflict check of SaveConversation.
+func lockFile(path string) (func() error, error) {
+	return func() error { return nil }, nil
+}
@@ -0,0 +1,40 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockTimeout is how long lockFile waits for another process to release the lock.
+const lockTimeout = 10 * time.Second
+
+// lockFile takes an exclusive advisory lock on the file at path, creating
+// it if needed. The returned function releases the lock.
+func lockFile(path string) (func() error, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) || time.Now().After(deadline) {
+			_ = file.Close()
+			if errors.Is(err, syscall.EWOULDBLOCK) {
+				return nil, fmt.Errorf("%s is locked by another process", path)
+			}
+			return nil, err
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// closing the file releases the lock
+	return file.Close, nil
+}
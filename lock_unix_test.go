@@ -0,0 +1,73 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lock")
+	unlock, err := lockFile(path)
+	if err != nil {
+		t.Fatalf("lockFile failed: %v", err)
+	}
+
+	locked := make(chan time.Time)
+	go func() {
+		unlock, err := lockFile(path)
+		if err != nil {
+			t.Errorf("lockFile failed: %v", err)
+		} else {
+			_ = unlock()
+		}
+		locked <- time.Now()
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	released := time.Now()
+	if err := unlock(); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	if at := <-locked; at.Before(released) {
+		t.Error("Expected the second lock to wait for the first to be released")
+	}
+}
+
+func TestImportWaitsForHistoryLock(t *testing.T) {
+	useTestHistory(t)
+	path := filepath.Join(t.TempDir(), "chat.json")
+	if err := os.WriteFile(path, []byte(`[{"role": "user", "content": "Hi"}, {"role": "assistant", "content": "Hello"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	lockPath, err := historyStore.DataPath("lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockFile(lockPath)
+	if err != nil {
+		t.Fatalf("lockFile failed: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := ImportFile(path)
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if conversations, _ := historyStore.List(); len(conversations) != 0 {
+		t.Error("Expected the import to wait for the lock")
+	}
+	if err := unlock(); err != nil {
+		t.Fatalf("unlock failed: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("ImportFile failed: %v", err)
+	}
+	if conversations, _ := historyStore.List(); len(conversations) != 1 {
+		t.Errorf("Expected the conversation to be imported, got %d", len(conversations))
+	}
+}
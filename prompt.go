@@ -64,6 +64,24 @@ func (p *Prompt) CreateSubsequentMessages(output, input string) []gogpt.ChatComp
 	return messages
 }
 
+// ChatMessages splits the prompt for an interactive session. The messages
+// before the first one containing the input marker become the context of the
+// conversation, and that message, if any, is returned as the template for the
+// first user message.
+func (p *Prompt) ChatMessages() ([]gogpt.ChatCompletionMessage, *Message) {
+	messages := []gogpt.ChatCompletionMessage{}
+	for i, message := range p.Messages {
+		if strings.Contains(message.Content, p.InputMarker) {
+			return messages, &p.Messages[i]
+		}
+		messages = append(messages, gogpt.ChatCompletionMessage{
+			Role:    message.Role,
+			Content: message.Content,
+		})
+	}
+	return messages, nil
+}
+
 // CountTokens counts the number of tokens in the prompt
 func (p *Prompt) CountTokens(encoder *tokenizer.Encoder) (int, error) {
 	return countMessagesTokens(encoder, p.Messages)
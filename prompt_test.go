@@ -3,6 +3,8 @@ package main
 import (
 	"path/filepath"
 	"testing"
+
+	gogpt "github.com/sashabaranov/go-openai"
 )
 
 func TestLoadPrompts(t *testing.T) {
@@ -62,3 +64,33 @@ func TestSplitStringWithTokensLimit(t *testing.T) {
 		t.Errorf("expected 'world!', got %q", tokens[1])
 	}
 }
+
+func TestChatMessages(t *testing.T) {
+	prompt, err := NewPromptFromFile(filepath.Join("testdata", "name-branch.yml"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	messages, template := prompt.ChatMessages()
+	if len(messages) != 1 || messages[0].Role != gogpt.ChatMessageRoleSystem {
+		t.Errorf("expected the system message as context, got %+v", messages)
+	}
+	if template == nil || template.Content != "$INPUT" {
+		t.Errorf("expected the $INPUT message as template, got %+v", template)
+	}
+
+	prompt = &Prompt{
+		InputMarker: DefaultInputMarker,
+		Messages: []Message{
+			{Role: "system", Content: "Translate to French."},
+			{Role: "user", Content: "Hello"},
+			{Role: "assistant", Content: "Bonjour"},
+		},
+	}
+	messages, template = prompt.ChatMessages()
+	if len(messages) != 3 {
+		t.Errorf("expected all messages as context, got %d", len(messages))
+	}
+	if template != nil {
+		t.Errorf("expected no template, got %+v", template)
+	}
+}
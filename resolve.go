@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/chzyer/readline"
+)
+
+// minIDPrefix is the shortest ID prefix that is matched, as in git, so that
+// a short word is taken as part of a title instead.
+const minIDPrefix = 4
+
+// conversationChooser picks one of the conversations matching query.
+type conversationChooser func(query string, candidates []ConversationInfo) (ConversationInfo, error)
+
+// FindConversationID resolves query to the ID of a saved conversation. The
+// query is tried, ignoring case, as a whole ID, an ID prefix of at least
+// four characters, a whole title, and words contained in a title. When
+// several conversations match, choose picks one; with a nil choose, that is
+// an error listing them.
+func FindConversationID(query string, choose conversationChooser) (string, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return "", fmt.Errorf("no conversation given")
+	}
+	if validConversationID(query) {
+		if _, err := LoadConversation(query); err == nil {
+			return query, nil
+		}
+	}
+	conversations, err := ListConversationInfos()
+	if err != nil {
+		return "", err
+	}
+	lower := strings.ToLower(query)
+	words := strings.Fields(lower)
+	matchers := []func(conv ConversationInfo) bool{
+		func(conv ConversationInfo) bool {
+			return len(query) >= minIDPrefix && strings.HasPrefix(strings.ToLower(conv.ID), lower)
+		},
+		func(conv ConversationInfo) bool {
+			return strings.EqualFold(conv.Title, query)
+		},
+		func(conv ConversationInfo) bool {
+			title := strings.ToLower(conv.Title)
+			for _, word := range words {
+				if !strings.Contains(title, word) {
+					return false
+				}
+			}
+			return true
+		},
+	}
+	for _, match := range matchers {
+		var candidates []ConversationInfo
+		for _, conv := range conversations {
+			if match(conv) {
+				candidates = append(candidates, conv)
+			}
+		}
+		switch {
+		case len(candidates) == 0:
+			continue
+		case len(candidates) == 1:
+			return candidates[0].ID, nil
+		case choose == nil:
+			var list strings.Builder
+			printCandidates(&list, candidates, false)
+			return "", fmt.Errorf("%q matches %d conversations, give more of the ID:\n%s", query, len(candidates), strings.TrimRight(list.String(), "\n"))
+		}
+		chosen, err := choose(query, candidates)
+		if err != nil {
+			return "", err
+		}
+		return chosen.ID, nil
+	}
+	return "", fmt.Errorf("no conversation matches %q", query)
+}
+
+// FindConversationToDelete resolves query as FindConversationID does, but as
+// deleting cannot be undone, a conversation found by its title rather than
+// its ID or an ID prefix is only returned once confirm agrees. With a nil
+// confirm, that is an error.
+func FindConversationToDelete(query string, choose conversationChooser, confirm confirmFunc) (string, error) {
+	id, err := FindConversationID(query, choose)
+	if err != nil {
+		return "", err
+	}
+	query = strings.TrimSpace(query)
+	if len(query) >= minIDPrefix && strings.HasPrefix(strings.ToLower(id), strings.ToLower(query)) {
+		return id, nil
+	}
+	if confirm == nil {
+		return "", fmt.Errorf("%q matches conversation %s by its title, give its ID to delete it", query, shortID(id))
+	}
+	conv, err := LoadConversation(id)
+	if err != nil {
+		return "", err
+	}
+	ok, err := confirm(fmt.Sprintf("Delete %s %q?", shortID(id), conv.Title))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("not deleted")
+	}
+	return id, nil
+}
+
+// shortID is the start of an ID, enough to tell conversations apart.
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// printCandidates lists conversations with their short IDs, most recent first.
+func printCandidates(w io.Writer, candidates []ConversationInfo, numbered bool) {
+	for i, conv := range candidates {
+		number := ""
+		if numbered {
+			number = fmt.Sprintf("%d) ", i+1)
+		}
+		fmt.Fprintf(w, "  %s%s  %s  %s\n", number, shortID(conv.ID), conv.UpdatedAt.Local().Format("2006-01-02 15:04"), conv.Title)
+	}
+}
+
+// readerChooser asks the user to pick a conversation by its number.
+func readerChooser(reader lineReader) conversationChooser {
+	return func(query string, candidates []ConversationInfo) (ConversationInfo, error) {
+		fmt.Printf("%q matches %d conversations:\n", query, len(candidates))
+		printCandidates(os.Stdout, candidates, true)
+		answer, err := reader.ReadLine(fmt.Sprintf("Choose 1-%d: ", len(candidates)))
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, errInterrupt) {
+			return ConversationInfo{}, err
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(answer))
+		if err != nil || n < 1 || n > len(candidates) {
+			return ConversationInfo{}, fmt.Errorf("no conversation chosen")
+		}
+		return candidates[n-1], nil
+	}
+}
+
+// confirmFunc asks a yes/no question.
+type confirmFunc func(question string) (bool, error)
+
+// readerConfirm asks the user, taking anything but y as no.
+func readerConfirm(reader lineReader) confirmFunc {
+	return func(question string) (bool, error) {
+		answer, err := reader.ReadLine(question + " [y/N] ")
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, errInterrupt) {
+			return false, err
+		}
+		return strings.EqualFold(strings.TrimSpace(answer), "y"), nil
+	}
+}
+
+// terminalConfirm asks on the terminal, or returns nil when standard input
+// is not one.
+func terminalConfirm() confirmFunc {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	return readerConfirm(newScannerLineReader(os.Stdin, os.Stdout))
+}
+
+// terminalChooser asks on the terminal, or returns nil when standard input
+// is not one, so that scripts get an error instead of a question.
+func terminalChooser() conversationChooser {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	return readerChooser(newScannerLineReader(os.Stdin, os.Stdout))
+}
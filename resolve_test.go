@@ -0,0 +1,109 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFindConversationID(t *testing.T) {
+	useTestHistory(t)
+
+	first := NewConversation("Go generics", "gpt-4")
+	first.ID = "3f2a1b2c-0000-4000-8000-000000000001"
+	second := NewConversation("Duplicate", "gpt-4")
+	second.ID = "3f2a9d8e-0000-4000-8000-000000000002"
+	third := NewConversation("Duplicate", "gpt-4")
+	third.ID = "a7c41e00-0000-4000-8000-000000000003"
+	for _, conv := range []*Conversation{first, second, third} {
+		if err := SaveConversation(conv); err != nil {
+			t.Fatalf("Failed to save conversation: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query, id string
+	}{
+		{first.ID, first.ID},
+		{"3f2a1", first.ID},
+		{"A7C4", third.ID},
+		{"Go generics", first.ID},
+		{"go GENERICS", first.ID},
+		{"generics", first.ID},
+	}
+	for _, test := range tests {
+		if id, err := FindConversationID(test.query, nil); err != nil || id != test.id {
+			t.Errorf("%q: expected %s, got %q, %v", test.query, test.id, id, err)
+		}
+	}
+
+	_, err := FindConversationID("3f2a", nil)
+	if err == nil || !strings.Contains(err.Error(), "3f2a1b2c") || !strings.Contains(err.Error(), "3f2a9d8e") {
+		t.Errorf("Expected an ambiguous prefix to list the matches, got %v", err)
+	}
+	// a conversation file outside the history can't be reached by a path
+	outside := t.TempDir()
+	data, err := encodeConversation(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.yml"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	escape := filepath.Join("..", filepath.Base(outside), "secret")
+
+	for _, query := range []string{"Duplicate", "missing", "3f2", "", escape} {
+		if _, err := FindConversationID(query, nil); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+
+	var offered []ConversationInfo
+	choose := func(query string, candidates []ConversationInfo) (ConversationInfo, error) {
+		offered = candidates
+		return candidates[1], nil
+	}
+	if id, err := FindConversationID("duplicate", choose); err != nil || len(offered) != 2 || id != offered[1].ID {
+		t.Errorf("Expected the chosen conversation, got %q, %v", id, err)
+	}
+}
+
+func TestFindConversationToDelete(t *testing.T) {
+	useTestHistory(t)
+
+	conv := NewConversation("Old notes", "gpt-4")
+	if err := SaveConversation(conv); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	if id, err := FindConversationToDelete(conv.ID[:6], nil, nil); err != nil || id != conv.ID {
+		t.Errorf("Expected an ID prefix to be enough, got %q, %v", id, err)
+	}
+	if _, err := FindConversationToDelete("notes", nil, nil); err == nil {
+		t.Error("Expected a title match to need confirmation")
+	}
+	var asked string
+	confirm := func(answer bool) confirmFunc {
+		return func(question string) (bool, error) {
+			asked = question
+			return answer, nil
+		}
+	}
+	if _, err := FindConversationToDelete("notes", nil, confirm(false)); err == nil || !strings.Contains(asked, "Old notes") {
+		t.Errorf("Expected declining to fail after showing the title, got %v, asked %q", err, asked)
+	}
+	if id, err := FindConversationToDelete("notes", nil, confirm(true)); err != nil || id != conv.ID {
+		t.Errorf("Expected confirming to return the conversation, got %q, %v", id, err)
+	}
+}
+
+func TestReaderChooser(t *testing.T) {
+	candidates := []ConversationInfo{{ID: "1", Title: "One"}, {ID: "2", Title: "Two"}}
+	for answer, want := range map[string]string{"2\n": "2", "3\n": "", "\n": ""} {
+		reader := newScannerLineReader(strings.NewReader(answer), &strings.Builder{})
+		chosen, err := readerChooser(reader)("dup", candidates)
+		if chosen.ID != want || (want == "") != (err != nil) {
+			t.Errorf("%q: expected %v, got %v, %v", answer, want, chosen, err)
+		}
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchIndexVersion is increased whenever the index format or the way text
+// is split into terms changes, so that old indexes are rebuilt.
+const searchIndexVersion = 3
+
+// searchIndexFile is the name of the index kept with the history.
+const searchIndexFile = "search-index"
+
+// searchIndex is an inverted index from terms to the conversations containing
+// them. It is stored with the history and brought up to date before every
+// search by reindexing the conversations that changed since the last one.
+type searchIndex struct {
+	Version int
+	Docs    map[string]*indexedConversation
+	// Postings maps a term to the IDs of the conversations containing it.
+	Postings map[string]map[string]bool
+	// Terms are the terms of Postings in order, to find the ones starting
+	// with a query term without scanning them all.
+	Terms []string
+}
+
+// indexedConversation records what was indexed for a conversation.
+type indexedConversation struct {
+	Model string
+	// Version is the store's version of the conversation when it was indexed.
+	Version string
+	Terms   []string
+}
+
+// searchQuery is a search with its filters. Zero values don't filter.
+type searchQuery struct {
+	Text  string
+	Model string
+	Since time.Time
+	Until time.Time
+}
+
+// searchHit is a message matching a search.
+type searchHit struct {
+	ConversationID string
+	Title          string
+	Role           string
+	Time           time.Time
+	Snippet        string
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		Version:  searchIndexVersion,
+		Docs:     map[string]*indexedConversation{},
+		Postings: map[string]map[string]bool{},
+	}
+}
+
+// loadSearchIndex reads the index, or returns an empty one when it is
+// missing, unreadable or from another version.
+func loadSearchIndex(path string) *searchIndex {
+	file, err := os.Open(path)
+	if err != nil {
+		return newSearchIndex()
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+	index := &searchIndex{}
+	if err := gob.NewDecoder(file).Decode(index); err != nil || index.Version != searchIndexVersion {
+		return newSearchIndex()
+	}
+	if index.Docs == nil {
+		index.Docs = map[string]*indexedConversation{}
+	}
+	if index.Postings == nil {
+		index.Postings = map[string]map[string]bool{}
+	}
+	if len(index.Terms) != len(index.Postings) {
+		index.Terms = sortedKeys(index.Postings)
+	}
+	return index
+}
+
+func (ix *searchIndex) save(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if err := gob.NewEncoder(tmp).Encode(ix); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// update reindexes the conversations of the store that changed, and drops
+// the deleted ones. It reports whether the index changed.
+func (ix *searchIndex) update(store HistoryStore) (bool, error) {
+	versions, err := store.Versions()
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	for id, version := range versions {
+		if doc := ix.Docs[id]; doc != nil && doc.Version == version {
+			continue
+		}
+		ix.remove(id)
+		changed = true
+		conv, err := store.Load(id)
+		if err != nil {
+			continue // Skip conversations that can't be loaded
+		}
+		ix.add(id, conv, version)
+	}
+	for id := range ix.Docs {
+		if _, ok := versions[id]; !ok {
+			ix.remove(id)
+			changed = true
+		}
+	}
+	if changed {
+		ix.Terms = sortedKeys(ix.Postings)
+	}
+	return changed, nil
+}
+
+func (ix *searchIndex) add(id string, conv *Conversation, version string) {
+	terms := map[string]bool{}
+	for _, term := range searchTerms(conv.Title) {
+		terms[term] = true
+	}
+	for _, msg := range conv.Messages {
+		for _, term := range searchTerms(msg.Content) {
+			terms[term] = true
+		}
+	}
+	doc := &indexedConversation{Model: conv.Model, Version: version}
+	for term := range terms {
+		doc.Terms = append(doc.Terms, term)
+		if ix.Postings[term] == nil {
+			ix.Postings[term] = map[string]bool{}
+		}
+		ix.Postings[term][id] = true
+	}
+	ix.Docs[id] = doc
+}
+
+func (ix *searchIndex) remove(id string) {
+	doc := ix.Docs[id]
+	if doc == nil {
+		return
+	}
+	for _, term := range doc.Terms {
+		delete(ix.Postings[term], id)
+		if len(ix.Postings[term]) == 0 {
+			delete(ix.Postings, term)
+		}
+	}
+	delete(ix.Docs, id)
+}
+
+// candidates returns the IDs of the conversations containing every term.
+func (ix *searchIndex) candidates(terms []string) map[string]bool {
+	var result map[string]bool
+	for _, term := range terms {
+		found := map[string]bool{}
+		for _, ids := range ix.postingsOf(term) {
+			for id := range ids {
+				if result == nil || result[id] {
+					found[id] = true
+				}
+			}
+		}
+		result = found
+		if len(result) == 0 {
+			break
+		}
+	}
+	return result
+}
+
+// postingsOf returns the postings of the indexed terms matching term. The
+// terms starting with it are found in Terms; only a single CJK character,
+// which may also end a pair, needs a scan of every term.
+func (ix *searchIndex) postingsOf(term string) []map[string]bool {
+	var postings []map[string]bool
+	if runes := []rune(term); len(runes) == 1 && isCJK(runes[0]) {
+		for indexed, ids := range ix.Postings {
+			if termMatches(indexed, term) {
+				postings = append(postings, ids)
+			}
+		}
+		return postings
+	}
+	for i := sort.SearchStrings(ix.Terms, term); i < len(ix.Terms) && strings.HasPrefix(ix.Terms[i], term); i++ {
+		postings = append(postings, ix.Postings[ix.Terms[i]])
+	}
+	return postings
+}
+
+// SearchConversations returns the messages of saved conversations containing
+// every word of the query, newest conversations first.
+func SearchConversations(query searchQuery) ([]searchHit, error) {
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("nothing to search for")
+	}
+	indexPath, err := historyStore.DataPath(searchIndexFile)
+	if err != nil {
+		return nil, err
+	}
+	index := loadSearchIndex(indexPath)
+	changed, err := index.update(historyStore)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := index.save(indexPath); err != nil {
+			return nil, fmt.Errorf("saving search index: %w", err)
+		}
+	}
+
+	var conversations []*Conversation
+	for id := range index.candidates(terms) {
+		if query.Model != "" && index.Docs[id].Model != query.Model {
+			continue
+		}
+		conv, err := LoadConversation(id)
+		if err != nil {
+			continue
+		}
+		conversations = append(conversations, conv)
+	}
+	sort.Slice(conversations, func(i, j int) bool {
+		return conversations[i].UpdatedAt.After(conversations[j].UpdatedAt)
+	})
+
+	var hits []searchHit
+	for _, conv := range conversations {
+		for _, msg := range conv.Messages {
+			if !query.Since.IsZero() && msg.Time.Before(query.Since) ||
+				!query.Until.IsZero() && !msg.Time.Before(query.Until) {
+				continue
+			}
+			if !containsTerms(msg.Content, terms) {
+				continue
+			}
+			hits = append(hits, searchHit{
+				ConversationID: conv.ID,
+				Title:          conv.Title,
+				Role:           msg.Role,
+				Time:           msg.Time,
+				Snippet:        searchSnippet(msg.Content, query.Text, 80),
+			})
+		}
+	}
+	return hits, nil
+}
+
+// searchTerms splits text into lower-case terms: words for alphabetic
+// scripts, and overlapping pairs of characters for Chinese, Japanese and
+// Korean, which are written without spaces.
+func searchTerms(text string) []string {
+	var terms []string
+	var word []rune
+	cjk := false
+	flush := func() {
+		switch {
+		case cjk && len(word) > 1:
+			for i := 0; i+1 < len(word); i++ {
+				terms = append(terms, string(word[i:i+2]))
+			}
+		case len(word) > 0:
+			terms = append(terms, string(word))
+		}
+		word = word[:0]
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case isCJK(r):
+			if !cjk {
+				flush()
+				cjk = true
+			}
+			word = append(word, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if cjk {
+				flush()
+				cjk = false
+			}
+			word = append(word, r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return terms
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// termMatches reports whether an indexed term matches a query term. Words
+// match by prefix; a single CJK character matches the pairs containing it.
+func termMatches(indexed, term string) bool {
+	if runes := []rune(term); len(runes) == 1 && isCJK(runes[0]) {
+		return strings.ContainsRune(indexed, runes[0])
+	}
+	return strings.HasPrefix(indexed, term)
+}
+
+func containsTerms(content string, terms []string) bool {
+	contentTerms := searchTerms(content)
+	for _, term := range terms {
+		found := false
+		for _, contentTerm := range contentTerms {
+			if termMatches(contentTerm, term) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// searchSnippet returns about width runes of content on one line around the
+// first occurrence of a word of the query.
+func searchSnippet(content, query string, width int) string {
+	runes := []rune(strings.Join(strings.Fields(content), " "))
+	lower := []rune(strings.ToLower(string(runes)))
+	start := 0
+	if len(lower) == len(runes) {
+		for _, word := range strings.Fields(strings.ToLower(query)) {
+			if i := strings.Index(string(lower), word); i >= 0 {
+				start = max(len([]rune(string(lower)[:i]))-width/4, 0)
+				break
+			}
+		}
+	}
+	end := min(start+width, len(runes))
+	snippet := string(runes[start:end])
+	if start > 0 {
+		snippet = "..." + snippet
+	}
+	if end < len(runes) {
+		snippet += "..."
+	}
+	return snippet
+}
+
+// parseSearchQuery parses the query of /search, in which model:NAME,
+// since:DATE and until:DATE filter the results.
+func parseSearchQuery(input string) (searchQuery, error) {
+	var query searchQuery
+	var words []string
+	for _, word := range strings.Fields(input) {
+		key, value, ok := strings.Cut(word, ":")
+		var err error
+		switch {
+		case ok && key == "model":
+			query.Model = value
+		case ok && key == "since":
+			query.Since, err = parseDate(value, false)
+		case ok && key == "until":
+			query.Until, err = parseDate(value, true)
+		default:
+			words = append(words, word)
+		}
+		if err != nil {
+			return query, err
+		}
+	}
+	query.Text = strings.Join(words, " ")
+	return query, nil
+}
+
+// parseDate parses a date as YYYY-MM-DD in local time, or as RFC 3339. A
+// date given for the end of a range includes the whole day.
+func parseDate(value string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD", value)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// printSearchHits lists the hits grouped by conversation.
+func printSearchHits(hits []searchHit) {
+	if len(hits) == 0 {
+		fmt.Println("No matches.")
+		return
+	}
+	for i, hit := range hits {
+		if i == 0 || hits[i-1].ConversationID != hit.ConversationID {
+			fmt.Printf("%s: %s\n", hit.ConversationID, hit.Title)
+		}
+		fmt.Printf("  %-9s %s  %s\n", hit.Role+":", hit.Time.Local().Format("2006-01-02 15:04"), hit.Snippet)
+	}
+}
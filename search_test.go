@@ -0,0 +1,160 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		text     string
+		expected []string
+	}{
+		{"Hello, World! go1.24", []string{"hello", "world", "go1", "24"}},
+		{"日本語の質問", []string{"日本", "本語", "語の", "の質", "質問"}},
+		{"Goで書く", []string{"go", "で書", "書く"}},
+		{"字", []string{"字"}},
+	}
+	for _, test := range tests {
+		if got := searchTerms(test.text); !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%q: expected %q, got %q", test.text, test.expected, got)
+		}
+	}
+}
+
+func TestSearchConversations(t *testing.T) {
+	tempDir := useTestHistory(t)
+
+	golang := NewConversation("Go generics", "gpt-4")
+	golang.AddMessage("user", "How do generics work in Go?")
+	golang.AddMessage("assistant", "Type parameters are declared in square brackets.")
+	japanese := NewConversation("日本語", "gpt-4o")
+	japanese.AddMessage("user", "東京の天気を教えて")
+	for _, conv := range []*Conversation{golang, japanese} {
+		if err := SaveConversation(conv); err != nil {
+			t.Fatalf("Failed to save conversation: %v", err)
+		}
+	}
+
+	search := func(query searchQuery) []searchHit {
+		t.Helper()
+		hits, err := SearchConversations(query)
+		if err != nil {
+			t.Fatalf("SearchConversations(%+v) returned an error: %v", query, err)
+		}
+		return hits
+	}
+
+	hits := search(searchQuery{Text: "GENERIC go"})
+	if len(hits) != 1 || hits[0].ConversationID != golang.ID || hits[0].Role != "user" {
+		t.Errorf("Expected the user message to match by prefix, got %+v", hits)
+	}
+	if hits := search(searchQuery{Text: "天気"}); len(hits) != 1 || hits[0].Title != "日本語" {
+		t.Errorf("Expected a CJK match, got %+v", hits)
+	}
+	if hits := search(searchQuery{Text: "京"}); len(hits) != 1 {
+		t.Errorf("Expected a single CJK character to match, got %+v", hits)
+	}
+	if hits := search(searchQuery{Text: "brackets", Model: "gpt-4o"}); len(hits) != 0 {
+		t.Errorf("Expected the model filter to exclude the hit, got %+v", hits)
+	}
+	if hits := search(searchQuery{Text: "brackets", Since: time.Now().Add(time.Hour)}); len(hits) != 0 {
+		t.Errorf("Expected the date filter to exclude the hit, got %+v", hits)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "."+searchIndexFile)); err != nil {
+		t.Errorf("Expected the index to be saved: %v", err)
+	}
+
+	// the index follows changes and deletions
+	golang.AddMessage("user", "What about constraints?")
+	if err := SaveConversation(golang); err != nil {
+		t.Fatalf("Failed to save conversation: %v", err)
+	}
+	if hits := search(searchQuery{Text: "constraints"}); len(hits) != 1 {
+		t.Errorf("Expected the new message to be found, got %+v", hits)
+	}
+	if err := DeleteConversation(japanese.ID); err != nil {
+		t.Fatalf("Failed to delete conversation: %v", err)
+	}
+	if hits := search(searchQuery{Text: "天気"}); len(hits) != 0 {
+		t.Errorf("Expected the deleted conversation to be gone, got %+v", hits)
+	}
+	index := loadSearchIndex(filepath.Join(tempDir, "."+searchIndexFile))
+	if len(index.Docs) != 1 || index.Postings["天気"] != nil {
+		t.Errorf("Expected the deleted conversation to be removed from the index, got %+v", index.Docs)
+	}
+
+	if _, err := SearchConversations(searchQuery{Text: " ? "}); err == nil {
+		t.Error("Expected an error for an empty query")
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	query, err := parseSearchQuery("error handling model:gpt-4 since:2024-01-01 until:2024-01-31")
+	if err != nil {
+		t.Fatalf("parseSearchQuery returned an error: %v", err)
+	}
+	if query.Text != "error handling" || query.Model != "gpt-4" {
+		t.Errorf("Unexpected query: %+v", query)
+	}
+	if query.Since.Format(time.DateOnly) != "2024-01-01" || query.Until.Format(time.DateOnly) != "2024-02-01" {
+		t.Errorf("Expected the until date to include the whole day, got %v - %v", query.Since, query.Until)
+	}
+	if _, err := parseSearchQuery("x since:yesterday"); err == nil {
+		t.Error("Expected an invalid date to fail")
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	content := strings.Repeat("padding ", 20) + "the Needle\nis here " + strings.Repeat("tail ", 20)
+	snippet := searchSnippet(content, "needle", 40)
+	if !strings.HasPrefix(snippet, "...") || !strings.HasSuffix(snippet, "...") || !strings.Contains(snippet, "the Needle is here") {
+		t.Errorf("Unexpected snippet: %q", snippet)
+	}
+	if got := searchSnippet("short", "short", 40); got != "short" {
+		t.Errorf("Expected a short message as is, got %q", got)
+	}
+}
+
+func TestSearchIndexCandidates(t *testing.T) {
+	useTestHistory(t)
+	var ids []string
+	for _, title := range []string{"golang generics", "go routines", "gopher"} {
+		conv := NewConversation(title, "gpt-4")
+		if err := SaveConversation(conv); err != nil {
+			t.Fatalf("Failed to save conversation: %v", err)
+		}
+		ids = append(ids, conv.ID)
+	}
+	index := newSearchIndex()
+	if _, err := index.update(historyStore); err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if !sort.StringsAreSorted(index.Terms) || len(index.Terms) != len(index.Postings) {
+		t.Fatalf("Expected the terms of the postings in order, got %q", index.Terms)
+	}
+
+	for _, test := range []struct {
+		terms []string
+		found int
+	}{
+		{[]string{"go"}, 3},
+		{[]string{"golang"}, 1},
+		{[]string{"gol", "gen"}, 1},
+		{[]string{"go", "routines"}, 1},
+		{[]string{"goz"}, 0},
+		{[]string{"a"}, 0},
+	} {
+		if found := index.candidates(test.terms); len(found) != test.found {
+			t.Errorf("Expected %d conversations for %q, got %v", test.found, test.terms, found)
+		}
+	}
+	if found := index.candidates([]string{"gopher"}); !found[ids[2]] {
+		t.Errorf("Expected the exact term to be found, got %v", found)
+	}
+}
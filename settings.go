@@ -0,0 +1,123 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// setConversation makes conv the current conversation and resumes the
+// settings recorded in it, or the default ones when it has none. Files
+// attached for the previous conversation are dropped.
+func (aiChat *AIChat) setConversation(conv *Conversation) {
+	aiChat.conversation = conv
+	aiChat.inputTemplate = nil
+	aiChat.attachments = nil
+	aiChat.options.model = cmp.Or(conv.Model, aiChat.defaults.model)
+	settings := ChatSettings{
+		Temperature:  aiChat.defaults.temperature,
+		MaxTokens:    aiChat.defaults.maxTokens,
+		NonStreaming: aiChat.defaults.nonStreaming,
+	}
+	if conv.Settings != nil {
+		settings = *conv.Settings
+	}
+	aiChat.options.temperature = settings.Temperature
+	aiChat.options.maxTokens = settings.MaxTokens
+	aiChat.options.nonStreaming = settings.NonStreaming
+	aiChat.recordSettings()
+}
+
+// startPrompt starts a new conversation seeded with the messages of a prompt
+// template as its system and few-shot context. The template's temperature
+// applies to the session, and its max tokens unless given on the command line,
+// as in prompt mode. Its input message, if any, wraps the first message typed
+// by the user.
+func (aiChat *AIChat) startPrompt(name string, prompt *Prompt) {
+	conv := NewConversation(name, aiChat.options.model)
+	conv.Prompt = name
+	messages, template := prompt.ChatMessages()
+	for _, message := range messages {
+		conv.AddMessage(message.Role, message.Content)
+	}
+	aiChat.setConversation(conv)
+	aiChat.options.temperature = firstNonZeroFloat32(prompt.Temperature, aiChat.options.temperature)
+	aiChat.options.maxTokens = firstNonZeroInt(aiChat.options.maxTokens, prompt.MaxTokens)
+	aiChat.recordSettings()
+
+	if template != nil {
+		content, marker := template.Content, prompt.InputMarker
+		aiChat.inputTemplate = func(input string) string {
+			return strings.ReplaceAll(content, marker, input)
+		}
+	}
+}
+
+// recordSettings stores the current settings in the conversation so that a
+// reloaded session continues with them.
+func (aiChat *AIChat) recordSettings() {
+	if aiChat.conversation == nil {
+		return
+	}
+	aiChat.conversation.Model = aiChat.options.model
+	aiChat.conversation.Settings = &ChatSettings{
+		Temperature:  aiChat.options.temperature,
+		MaxTokens:    aiChat.options.maxTokens,
+		NonStreaming: aiChat.options.nonStreaming,
+	}
+}
+
+// setSetting changes a session setting by its slash command name.
+func (aiChat *AIChat) setSetting(name, value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fmt.Errorf("usage: /%s <value>", name)
+	}
+	switch name {
+	case "model":
+		aiChat.options.model = value
+	case "temperature":
+		temperature, err := strconv.ParseFloat(value, 32)
+		if err != nil || temperature < 0 || temperature > 2 {
+			return fmt.Errorf("temperature must be a number between 0 and 2: %q", value)
+		}
+		aiChat.options.temperature = float32(temperature)
+	case "max-tokens":
+		maxTokens, err := strconv.Atoi(value)
+		if err != nil || maxTokens < 0 {
+			return fmt.Errorf("max tokens must be a non-negative integer, 0 to use default: %q", value)
+		}
+		aiChat.options.maxTokens = maxTokens
+	case "stream":
+		switch value {
+		case "on":
+			aiChat.options.nonStreaming = false
+		case "off":
+			aiChat.options.nonStreaming = true
+		default:
+			return fmt.Errorf("stream must be on or off: %q", value)
+		}
+	default:
+		return fmt.Errorf("unknown setting: %s", name)
+	}
+	aiChat.recordSettings()
+	return nil
+}
+
+// printSettings shows the settings used for the next request.
+func (aiChat *AIChat) printSettings() {
+	stream := "on"
+	if aiChat.options.nonStreaming {
+		stream = "off"
+	}
+	maxTokens := "default"
+	if aiChat.options.maxTokens != 0 {
+		maxTokens = strconv.Itoa(aiChat.options.maxTokens)
+	}
+	fmt.Println("Current settings:")
+	fmt.Printf("  model:       %s\n", aiChat.options.model)
+	fmt.Printf("  temperature: %g\n", aiChat.options.temperature)
+	fmt.Printf("  max tokens:  %s\n", maxTokens)
+	fmt.Printf("  stream:      %s\n", stream)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSetSetting(t *testing.T) {
+	aiChat := &AIChat{
+		options:      chatOptions{model: "gpt-4", temperature: 0.5},
+		conversation: NewConversation("Test", "gpt-4"),
+	}
+
+	if err := aiChat.setSetting("model", "o4-mini"); err != nil {
+		t.Fatalf("setSetting(model) returned an error: %v", err)
+	}
+	if err := aiChat.setSetting("temperature", "0"); err != nil {
+		t.Fatalf("setSetting(temperature) returned an error: %v", err)
+	}
+	if err := aiChat.setSetting("max-tokens", "256"); err != nil {
+		t.Fatalf("setSetting(max-tokens) returned an error: %v", err)
+	}
+	if err := aiChat.setSetting("stream", "off"); err != nil {
+		t.Fatalf("setSetting(stream) returned an error: %v", err)
+	}
+
+	expected := chatOptions{model: "o4-mini", temperature: 0, maxTokens: 256, nonStreaming: true}
+	if aiChat.options != expected {
+		t.Errorf("Expected options %+v, got %+v", expected, aiChat.options)
+	}
+
+	conv := aiChat.conversation
+	if conv.Model != "o4-mini" {
+		t.Errorf("Expected model to be recorded, got %q", conv.Model)
+	}
+	if conv.Settings == nil || *conv.Settings != (ChatSettings{Temperature: 0, MaxTokens: 256, NonStreaming: true}) {
+		t.Errorf("Expected settings to be recorded, got %+v", conv.Settings)
+	}
+
+	for _, invalid := range [][2]string{
+		{"temperature", "hot"},
+		{"temperature", "3"},
+		{"max-tokens", "-1"},
+		{"stream", "maybe"},
+		{"model", ""},
+		{"unknown", "1"},
+	} {
+		if err := aiChat.setSetting(invalid[0], invalid[1]); err == nil {
+			t.Errorf("Expected setSetting(%q, %q) to fail", invalid[0], invalid[1])
+		}
+	}
+}
+
+func TestSetConversation(t *testing.T) {
+	defaults := chatOptions{model: "gpt-4", temperature: 0.5}
+	aiChat := &AIChat{options: defaults, defaults: defaults}
+
+	conv := NewConversation("Old", "gpt-3.5-turbo")
+	aiChat.setConversation(conv)
+	if aiChat.options.model != "gpt-3.5-turbo" || aiChat.options.temperature != 0.5 {
+		t.Errorf("Expected only the model to be taken from a conversation without settings, got %+v", aiChat.options)
+	}
+
+	conv = NewConversation("New", "o4-mini")
+	conv.Settings = &ChatSettings{Temperature: 0.2, MaxTokens: 100, NonStreaming: true}
+	aiChat.setConversation(conv)
+	expected := chatOptions{model: "o4-mini", temperature: 0.2, maxTokens: 100, nonStreaming: true}
+	if aiChat.options != expected {
+		t.Errorf("Expected options %+v, got %+v", expected, aiChat.options)
+	}
+	if aiChat.conversation != conv {
+		t.Error("Expected conversation to be set")
+	}
+
+	// a conversation without settings does not inherit the previous session's
+	conv = NewConversation("Older", "gpt-3.5-turbo")
+	aiChat.setConversation(conv)
+	expected = chatOptions{model: "gpt-3.5-turbo", temperature: 0.5}
+	if aiChat.options != expected {
+		t.Errorf("Expected the default settings %+v, got %+v", expected, aiChat.options)
+	}
+	aiChat.attachments = []attachment{{path: "notes.txt", content: "notes"}}
+	aiChat.setConversation(NewConversation("Oldest", ""))
+	if aiChat.options != defaults || aiChat.attachments != nil {
+		t.Errorf("Expected the default model and no attachments, got %+v, %d attachments", aiChat.options, len(aiChat.attachments))
+	}
+}
+
+func TestStartPrompt(t *testing.T) {
+	defaults := chatOptions{model: "gpt-4", temperature: 0.5, maxTokens: 100}
+	aiChat := &AIChat{options: defaults, defaults: defaults}
+	prompt := &Prompt{
+		InputMarker: DefaultInputMarker,
+		Messages: []Message{
+			{Role: "system", Content: "Translate to French."},
+			{Role: "user", Content: "Translate: $INPUT"},
+		},
+		Temperature: 0.2,
+	}
+
+	aiChat.startPrompt("translate", prompt)
+
+	conv := aiChat.conversation
+	if conv == nil || conv.Prompt != "translate" {
+		t.Fatalf("Expected a conversation started from the prompt, got %+v", conv)
+	}
+	if len(conv.Messages) != 1 || conv.Messages[0].Content != "Translate to French." {
+		t.Errorf("Expected the system message as context, got %+v", conv.Messages)
+	}
+	if aiChat.options.temperature != 0.2 || aiChat.options.maxTokens != 100 {
+		t.Errorf("Expected the prompt temperature and the existing max tokens, got %+v", aiChat.options)
+	}
+	if conv.Settings == nil || conv.Settings.Temperature != 0.2 {
+		t.Errorf("Expected settings to be recorded, got %+v", conv.Settings)
+	}
+	if aiChat.inputTemplate == nil {
+		t.Fatal("Expected an input template")
+	}
+	if got := aiChat.inputTemplate("Hello"); got != "Translate: Hello" {
+		t.Errorf("Expected %q, got %q", "Translate: Hello", got)
+	}
+
+	aiChat.setConversation(NewConversation("Other", "gpt-4"))
+	if aiChat.inputTemplate != nil {
+		t.Error("Expected the input template to be dropped with the conversation")
+	}
+
+	// --max-tokens wins over the template's, which applies otherwise
+	prompt.MaxTokens = 300
+	aiChat.startPrompt("translate", prompt)
+	if aiChat.options.maxTokens != 100 {
+		t.Errorf("Expected the max tokens given on the command line, got %d", aiChat.options.maxTokens)
+	}
+	aiChat.defaults.maxTokens = 0
+	aiChat.startPrompt("translate", prompt)
+	if aiChat.options.maxTokens != 300 {
+		t.Errorf("Expected the template's max tokens, got %d", aiChat.options.maxTokens)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// shellCommand returns the command line to run command with the user's shell.
+func shellCommand(command string) *exec.Cmd {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+	return exec.Command(shell, "-c", command)
+}
+
+// runShellCommand runs command, copying its stdout and stderr to out as they
+// are written, and returns the combined output and the exit code. An error is
+// returned only when the command could not be run.
+func runShellCommand(command string, out io.Writer) (string, int, error) {
+	var output strings.Builder
+	writer := io.MultiWriter(out, &output)
+	cmd := shellCommand(command)
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return output.String(), exitErr.ExitCode(), nil
+	}
+	return output.String(), 0, err
+}
+
+// formatCommandOutput returns a user message presenting the command and its output.
+func formatCommandOutput(command, output string, exitCode int) string {
+	var message strings.Builder
+	fmt.Fprintf(&message, "Output of `%s`", command)
+	if exitCode != 0 {
+		fmt.Fprintf(&message, " (exit status %d)", exitCode)
+	}
+	message.WriteString(":\n\n")
+	message.WriteString(formatAttachment(attachment{content: output}))
+	return message.String()
+}
+
+// shellMessage runs command for /! and /!!, showing its output, and returns
+// the message presenting it to the model.
+func shellMessage(command string) (string, error) {
+	if command == "" {
+		return "", fmt.Errorf("usage: /! <command>")
+	}
+	output, exitCode, err := runShellCommand(command, os.Stdout)
+	if err != nil {
+		return "", err
+	}
+	if exitCode != 0 {
+		fmt.Printf("(exit status %d)\n", exitCode)
+	}
+	return formatCommandOutput(command, output, exitCode), nil
+}
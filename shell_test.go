@@ -0,0 +1,42 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRunShellCommand(t *testing.T) {
+	t.Setenv("SHELL", "/bin/sh")
+
+	var out strings.Builder
+	output, exitCode, err := runShellCommand("echo out; echo err >&2; exit 3", &out)
+	if err != nil {
+		t.Fatalf("runShellCommand() returned an error: %v", err)
+	}
+	if exitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", exitCode)
+	}
+	if output != "out\nerr\n" {
+		t.Errorf("Expected stdout and stderr in the output, got %q", output)
+	}
+	if out.String() != output {
+		t.Errorf("Expected the output to be shown, got %q", out.String())
+	}
+
+	t.Setenv("SHELL", "/nonexistent/shell")
+	if _, _, err := runShellCommand("true", &out); err == nil {
+		t.Error("Expected an error when the shell cannot be run")
+	}
+}
+
+func TestFormatCommandOutput(t *testing.T) {
+	got := formatCommandOutput("go build", "main.go:1: syntax error\n", 1)
+	expected := "Output of `go build` (exit status 1):\n\n```\nmain.go:1: syntax error\n```"
+	if got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	got = formatCommandOutput("true", "", 0)
+	if got != "Output of `true`:\n\n```\n\n```" {
+		t.Errorf("Unexpected output for a silent command: %q", got)
+	}
+}
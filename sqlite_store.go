@@ -0,0 +1,168 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore keeps the conversations in a single SQLite database. Each
+// conversation is stored as YAML, as in YAMLStore, with the columns needed to
+// list them.
+type SQLiteStore struct {
+	db   *sql.DB
+	path string
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS conversations (
+	id         TEXT PRIMARY KEY,
+	title      TEXT NOT NULL,
+	model      TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	data       BLOB NOT NULL,
+	revision   INTEGER NOT NULL DEFAULT 0
+)`
+
+// OpenSQLiteStore opens the database at path, creating it if needed.
+func OpenSQLiteStore(path string) (*SQLiteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	// other processes only take the history lock to write, so reads wait
+	// for their commits instead of failing with "database is locked"
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	// a single connection avoids "database is locked" errors between our own writes
+	db.SetMaxOpenConns(1)
+	if err := migrateSQLite(db); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &SQLiteStore{db: db, path: path}, nil
+}
+
+// migrateSQLite creates the table, or adds the revision column to a table
+// created before conversations had one.
+func migrateSQLite(db *sql.DB) error {
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('conversations') WHERE name = 'revision'`).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = db.Exec(`ALTER TABLE conversations ADD COLUMN revision INTEGER NOT NULL DEFAULT 0`)
+	return err
+}
+
+func (s *SQLiteStore) Save(conv *Conversation) error {
+	if !validConversationID(conv.ID) {
+		return fmt.Errorf("invalid conversation ID %q", conv.ID)
+	}
+	conv.Revision++
+	data, err := encodeConversation(conv)
+	if err != nil {
+		conv.Revision--
+		return err
+	}
+	_, err = s.db.Exec(`INSERT INTO conversations (id, title, model, created_at, updated_at, data, revision)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET title = excluded.title, model = excluded.model,
+			created_at = excluded.created_at, updated_at = excluded.updated_at, data = excluded.data,
+			revision = excluded.revision`,
+		conv.ID, conv.Title, conv.Model, conv.CreatedAt.UnixNano(), conv.UpdatedAt.UnixNano(), data, conv.Revision)
+	if err != nil {
+		conv.Revision--
+	}
+	return err
+}
+
+func (s *SQLiteStore) Load(id string) (*Conversation, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM conversations WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("conversation %s: %w", id, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeConversation(data)
+}
+
+func (s *SQLiteStore) List() ([]*Conversation, error) {
+	rows, err := s.db.Query(`SELECT data FROM conversations ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	conversations := []*Conversation{}
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		conversation, err := decodeConversation(data)
+		if err != nil {
+			continue // Skip conversations that can't be decoded
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, rows.Err()
+}
+
+func (s *SQLiteStore) Delete(id string) error {
+	result, err := s.db.Exec(`DELETE FROM conversations WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("conversation %s: %w", id, fs.ErrNotExist)
+	}
+	return err
+}
+
+// Versions returns the update time and revision of each conversation.
+func (s *SQLiteStore) Versions() (map[string]string, error) {
+	rows, err := s.db.Query(`SELECT id, updated_at, revision FROM conversations`)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	versions := map[string]string{}
+	for rows.Next() {
+		var id string
+		var updatedAt, revision int64
+		if err := rows.Scan(&id, &updatedAt, &revision); err != nil {
+			return nil, err
+		}
+		versions[id] = fmt.Sprintf("%d-%d", updatedAt, revision)
+	}
+	return versions, rows.Err()
+}
+
+// DataPath returns a file next to the database, named after it.
+func (s *SQLiteStore) DataPath(name string) (string, error) {
+	return s.path + "." + name, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HistoryStore keeps saved conversations.
+type HistoryStore interface {
+	// Save stores the conversation, replacing the one with the same ID, and
+	// bumps its Revision.
+	Save(conv *Conversation) error
+	// Load returns the conversation with the ID. The error wraps
+	// fs.ErrNotExist if there is none.
+	Load(id string) (*Conversation, error)
+	// List returns every stored conversation.
+	List() ([]*Conversation, error)
+	// Delete removes the conversation with the ID.
+	Delete(id string) error
+	// Versions returns, for each conversation ID, a value that changes
+	// whenever the conversation is saved.
+	Versions() (map[string]string, error)
+	// DataPath returns the path of a file kept with the history, such as
+	// the search index.
+	DataPath(name string) (string, error)
+	Close() error
+}
+
+// historyStore is the store used by SaveConversation, LoadConversation,
+// ListConversations and DeleteConversation.
+var historyStore HistoryStore = &YAMLStore{}
+
+// lazyStore opens the store on first use, so that commands that don't touch
+// the history work whatever its configuration.
+type lazyStore struct {
+	open  func() (HistoryStore, error)
+	once  sync.Once
+	store HistoryStore
+	err   error
+}
+
+func (s *lazyStore) get() (HistoryStore, error) {
+	s.once.Do(func() {
+		s.store, s.err = s.open()
+	})
+	return s.store, s.err
+}
+
+func (s *lazyStore) Save(conv *Conversation) error {
+	store, err := s.get()
+	if err != nil {
+		return err
+	}
+	return store.Save(conv)
+}
+
+func (s *lazyStore) Load(id string) (*Conversation, error) {
+	store, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return store.Load(id)
+}
+
+func (s *lazyStore) List() ([]*Conversation, error) {
+	store, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return store.List()
+}
+
+func (s *lazyStore) Delete(id string) error {
+	store, err := s.get()
+	if err != nil {
+		return err
+	}
+	return store.Delete(id)
+}
+
+func (s *lazyStore) Versions() (map[string]string, error) {
+	store, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	return store.Versions()
+}
+
+func (s *lazyStore) DataPath(name string) (string, error) {
+	store, err := s.get()
+	if err != nil {
+		return "", err
+	}
+	return store.DataPath(name)
+}
+
+// Close closes the store if it was opened. It can't be opened afterwards.
+func (s *lazyStore) Close() error {
+	s.once.Do(func() {
+		s.err = errors.New("history store is closed")
+	})
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Close()
+}
+
+// HistoryConfig selects where conversations are saved.
+type HistoryConfig struct {
+	// Store is "yaml" (the default) or "sqlite".
+	Store string `yaml:"store"`
+	// Dir is the directory of the YAML store, ~/.aichat/history by default.
+	Dir string `yaml:"dir"`
+	// Database is the file of the SQLite store, ~/.aichat/history.db by default.
+	Database string `yaml:"database"`
+}
+
+// OpenHistoryStore opens the store named by kind, "yaml" or "sqlite", at the
+// location given in the config.
+func OpenHistoryStore(config HistoryConfig, kind string) (HistoryStore, error) {
+	switch kind {
+	case "", "yaml":
+		dir, err := expandHome(config.Dir)
+		if err != nil {
+			return nil, err
+		}
+		return &YAMLStore{Dir: dir}, nil
+	case "sqlite":
+		path, err := expandHome(config.Database)
+		if err != nil {
+			return nil, err
+		}
+		if path == "" {
+			homeDir, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			path = filepath.Join(homeDir, ".aichat", "history.db")
+		}
+		return OpenSQLiteStore(path)
+	default:
+		return nil, fmt.Errorf("unknown history store %q, use yaml or sqlite", kind)
+	}
+}
+
+// expandHome replaces a leading ~/ in path with the home directory.
+func expandHome(path string) (string, error) {
+	rest, ok := strings.CutPrefix(path, "~/")
+	if !ok {
+		return path, nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, rest), nil
+}
+
+// MigrateHistory copies every conversation from one store to another,
+// keeping their IDs and timestamps. It returns the number copied.
+func MigrateHistory(from, to HistoryStore) (int, error) {
+	conversations, err := from.List()
+	if err != nil {
+		return 0, err
+	}
+	for _, conv := range conversations {
+		if err := to.Save(conv); err != nil {
+			return 0, fmt.Errorf("%s: %w", conv.ID, err)
+		}
+	}
+	return len(conversations), nil
+}
+
+func encodeConversation(conv *Conversation) ([]byte, error) {
+	return yaml.Marshal(conv)
+}
+
+func decodeConversation(data []byte) (*Conversation, error) {
+	conversation := &Conversation{}
+	if err := yaml.Unmarshal(data, conversation); err != nil {
+		return nil, err
+	}
+	conversation.normalize()
+	conversation.savedAt = conversation.UpdatedAt
+	return conversation, nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so that a crash leaves either the old or the new contents,
+// never a partial file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmp.Name())
+	}()
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// withHistoryLock runs fn holding the lock of the history store, so that
+// sessions sharing the history change it one at a time.
+func withHistoryLock(fn func() error) error {
+	path, err := historyStore.DataPath("lock")
+	if err != nil {
+		return err
+	}
+	unlock, err := lockFile(path)
+	if err != nil {
+		return fmt.Errorf("locking history: %w", err)
+	}
+	err = fn()
+	if unlockErr := unlock(); err == nil {
+		err = unlockErr
+	}
+	return err
+}
+
+// maxConversationIDLength bounds conversation IDs, which are UUIDs unless
+// imported.
+const maxConversationIDLength = 128
+
+// validConversationID reports whether id can name a conversation: letters,
+// digits, dashes and underscores only, so that it is safe as a file name.
+func validConversationID(id string) bool {
+	if id == "" || len(id) > maxConversationIDLength {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// YAMLStore keeps each conversation in a YAML file named after its ID.
+type YAMLStore struct {
+	// Dir is the directory of the files. When empty, GetHistoryDir is used.
+	Dir string
+}
+
+func (s *YAMLStore) dir() (string, error) {
+	if s.Dir == "" {
+		return GetHistoryDir()
+	}
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return "", err
+	}
+	return s.Dir, nil
+}
+
+func (s *YAMLStore) path(id string) (string, error) {
+	if !validConversationID(id) {
+		return "", fmt.Errorf("invalid conversation ID %q", id)
+	}
+	dir, err := s.dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s.yml", id)), nil
+}
+
+func (s *YAMLStore) Save(conv *Conversation) error {
+	path, err := s.path(conv.ID)
+	if err != nil {
+		return err
+	}
+	conv.Revision++
+	data, err := encodeConversation(conv)
+	if err == nil {
+		err = writeFileAtomic(path, data, 0600)
+	}
+	if err != nil {
+		conv.Revision--
+	}
+	return err
+}
+
+func (s *YAMLStore) Load(id string) (*Conversation, error) {
+	if !validConversationID(id) {
+		return nil, fmt.Errorf("conversation %q: %w", id, fs.ErrNotExist)
+	}
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeConversation(data)
+}
+
+func (s *YAMLStore) List() ([]*Conversation, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	conversations := []*Conversation{}
+	for _, id := range sortedKeys(ids) {
+		conversation, err := s.Load(id)
+		if err != nil {
+			continue // Skip files that can't be loaded
+		}
+		conversations = append(conversations, conversation)
+	}
+	return conversations, nil
+}
+
+func (s *YAMLStore) Delete(id string) error {
+	path, err := s.path(id)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Versions returns the modification time and size of each file.
+func (s *YAMLStore) Versions() (map[string]string, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+	versions := map[string]string{}
+	for id, info := range ids {
+		versions[id] = fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size())
+	}
+	return versions, nil
+}
+
+// DataPath returns a hidden file in the directory.
+func (s *YAMLStore) DataPath(name string) (string, error) {
+	dir, err := s.dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "."+name), nil
+}
+
+func (s *YAMLStore) Close() error {
+	return nil
+}
+
+// ids returns the file information of each conversation file by ID.
+func (s *YAMLStore) ids() (map[string]os.FileInfo, error) {
+	dir, err := s.dir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]os.FileInfo{}, nil
+		}
+		return nil, err
+	}
+	ids := map[string]os.FileInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".yml") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		ids[strings.TrimSuffix(entry.Name(), ".yml")] = info
+	}
+	return ids, nil
+}
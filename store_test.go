@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// useTestHistory keeps the history in a YAML store in a temporary directory
+// for the rest of the test, and returns the directory.
+func useTestHistory(t *testing.T) string {
+	dir := t.TempDir()
+	useTestStore(t, &YAMLStore{Dir: dir})
+	return dir
+}
+
+// useTestStore makes store the history store for the rest of the test.
+func useTestStore(t *testing.T, store HistoryStore) {
+	orig := historyStore
+	historyStore = store
+	t.Cleanup(func() { historyStore = orig })
+}
+
+func testStores(t *testing.T) map[string]HistoryStore {
+	sqlite, err := OpenSQLiteStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("Failed to open the SQLite store: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := sqlite.Close(); err != nil {
+			t.Errorf("Failed to close the SQLite store: %v", err)
+		}
+	})
+	return map[string]HistoryStore{
+		"yaml":   &YAMLStore{Dir: filepath.Join(t.TempDir(), "history")},
+		"sqlite": sqlite,
+	}
+}
+
+func TestHistoryStores(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			conv := NewConversation("Stored", "gpt-4")
+			conv.AddMessage("user", "Hello")
+			conv.AddMessage("assistant", "Hi!")
+			conv.Rewind(1)
+			conv.AddMessage("assistant", "Hello there!")
+			if err := store.Save(conv); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+
+			loaded, err := store.Load(conv.ID)
+			if err != nil {
+				t.Fatalf("Load failed: %v", err)
+			}
+			if loaded.Title != "Stored" || len(loaded.Messages) != 3 || loaded.ActiveLeaf != conv.ActiveLeaf {
+				t.Errorf("Unexpected conversation: %+v", loaded)
+			}
+			if !loaded.UpdatedAt.Equal(conv.UpdatedAt) {
+				t.Errorf("Expected the update time to be kept, got %v", loaded.UpdatedAt)
+			}
+
+			versions, err := store.Versions()
+			if err != nil {
+				t.Fatalf("Versions failed: %v", err)
+			}
+			before := versions[conv.ID]
+			conv.Title = "Renamed"
+			conv.UpdatedAt = conv.UpdatedAt.Add(1)
+			if err := store.Save(conv); err != nil {
+				t.Fatalf("Save failed: %v", err)
+			}
+			if versions, _ := store.Versions(); len(versions) != 1 || versions[conv.ID] == before {
+				t.Errorf("Expected the version to change when saved again, got %v", versions)
+			}
+
+			conversations, err := store.List()
+			if err != nil {
+				t.Fatalf("List failed: %v", err)
+			}
+			if len(conversations) != 1 || conversations[0].Title != "Renamed" {
+				t.Errorf("Unexpected list: %+v", conversations)
+			}
+
+			if err := store.Delete(conv.ID); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+			if _, err := store.Load(conv.ID); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Expected a deleted conversation not to exist, got %v", err)
+			}
+			if err := store.Delete(conv.ID); err == nil {
+				t.Error("Expected deleting a missing conversation to fail")
+			}
+
+			conv.ID = "../outside"
+			if err := store.Save(conv); err == nil {
+				t.Error("Expected an ID with a path in it to be rejected")
+			}
+			if _, err := store.Load(conv.ID); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("Expected an invalid ID not to exist, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLazyStore(t *testing.T) {
+	opened := 0
+	store := &lazyStore{open: func() (HistoryStore, error) {
+		opened++
+		return nil, errors.New("bad history config")
+	}}
+	if opened != 0 {
+		t.Error("Expected the store not to be opened before it is used")
+	}
+	if _, err := store.List(); err == nil || err.Error() != "bad history config" {
+		t.Errorf("Expected the open error, got %v", err)
+	}
+	if _, err := store.Load("x"); err == nil || opened != 1 {
+		t.Errorf("Expected the store to be opened once, got %d opens, %v", opened, err)
+	}
+	if err := store.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+
+	unused := &lazyStore{open: func() (HistoryStore, error) {
+		t.Error("Expected an unused store not to be opened")
+		return nil, nil
+	}}
+	if err := unused.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+func TestMigrateHistory(t *testing.T) {
+	stores := testStores(t)
+	for _, title := range []string{"First", "Second"} {
+		conv := NewConversation(title, "gpt-4")
+		conv.AddMessage("user", title)
+		if err := stores["yaml"].Save(conv); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	n, err := MigrateHistory(stores["yaml"], stores["sqlite"])
+	if err != nil {
+		t.Fatalf("MigrateHistory failed: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 conversations to be copied, got %d", n)
+	}
+	conversations, err := stores["sqlite"].List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(conversations) != 2 {
+		t.Errorf("Expected the conversations in the SQLite store, got %+v", conversations)
+	}
+
+	// migrating again replaces the copies
+	if _, err := MigrateHistory(stores["yaml"], stores["sqlite"]); err != nil {
+		t.Fatalf("MigrateHistory failed: %v", err)
+	}
+	if conversations, _ := stores["sqlite"].List(); len(conversations) != 2 {
+		t.Errorf("Expected no duplicates, got %d conversations", len(conversations))
+	}
+}
+
+func TestOpenHistoryStore(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	if store, err := OpenHistoryStore(HistoryConfig{Dir: "~/chats"}, "yaml"); err != nil || store.(*YAMLStore).Dir != "/home/test/chats" {
+		t.Errorf("Expected ~ to be expanded, got %+v, %v", store, err)
+	}
+	if _, err := OpenHistoryStore(HistoryConfig{}, "csv"); err == nil {
+		t.Error("Expected an unknown store to fail")
+	}
+	store, err := OpenHistoryStore(HistoryConfig{Database: filepath.Join(t.TempDir(), "h.db")}, "sqlite")
+	if err != nil {
+		t.Fatalf("OpenHistoryStore failed: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	if path, _ := store.DataPath(searchIndexFile); filepath.Base(path) != "h.db.search-index" {
+		t.Errorf("Expected the search index next to the database, got %q", path)
+	}
+}
+
+func TestSQLiteStoreMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conv := NewConversation("Old", "gpt-4")
+	data, err := encodeConversation(conv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the table as created before it had a revision column
+	_, err = db.Exec(`CREATE TABLE conversations (id TEXT PRIMARY KEY, title TEXT NOT NULL, model TEXT NOT NULL,
+		created_at INTEGER NOT NULL, updated_at INTEGER NOT NULL, data BLOB NOT NULL)`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO conversations VALUES (?, ?, ?, ?, ?, ?)`,
+			conv.ID, conv.Title, conv.Model, conv.CreatedAt.UnixNano(), conv.UpdatedAt.UnixNano(), data)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open the old database: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+	before, err := store.Versions()
+	if err != nil {
+		t.Fatalf("Versions failed: %v", err)
+	}
+	if err := store.Save(conv); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	after, _ := store.Versions()
+	if before[conv.ID] == "" || before[conv.ID] == after[conv.ID] {
+		t.Errorf("Expected saving to change the version, got %q and %q", before[conv.ID], after[conv.ID])
+	}
+}
+
+func TestSQLiteStoreWaitsForOtherWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	store, err := OpenSQLiteStore(path)
+	if err != nil {
+		t.Fatalf("Failed to open the SQLite store: %v", err)
+	}
+	defer func() {
+		_ = store.Close()
+	}()
+
+	// another process holding the database while it commits
+	other, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = other.Close()
+	}()
+	ctx := context.Background()
+	conn, err := other.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	if _, err := conn.ExecContext(ctx, `BEGIN EXCLUSIVE`); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		_, err := conn.ExecContext(ctx, `COMMIT`)
+		done <- err
+	}()
+	if _, err := store.Versions(); err != nil {
+		t.Errorf("Expected reading to wait for the other writer, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// defaultTitleModel is the model that names conversations unless title_model is set.
+const defaultTitleModel = gogpt.GPT4oMini
+
+// titleTimeout bounds the title request, so that a slow answer never holds up the chat.
+const titleTimeout = 15 * time.Second
+
+const titleInstruction = "Write a short title, at most six words, for the conversation below. " +
+	"Use the language of the conversation. Reply with the title only, without quotes."
+
+// pendingTitle is a title being generated in the background for a conversation.
+type pendingTitle struct {
+	conversationID string
+	// fallback is the title the conversation had when the request started;
+	// the generated title only replaces it if it is still the same.
+	fallback string
+	result   chan string
+	// err is why no title was generated, set before the result is sent.
+	err error
+}
+
+// generateTitle asks the title model to name the conversation.
+func (aiChat *AIChat) generateTitle(ctx context.Context, messages []gogpt.ChatCompletionMessage) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		if msg.Role == gogpt.ChatMessageRoleSystem {
+			continue
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n\n", msg.Role, summarizeContent(strings.Join(strings.Fields(msg.Content), " "), 1000))
+	}
+	model := aiChat.config.TitleModel
+	if model == "" {
+		model = defaultTitleModel
+	}
+	request := gogpt.ChatCompletionRequest{
+		Model: model,
+		Messages: []gogpt.ChatCompletionMessage{
+			{Role: gogpt.ChatMessageRoleSystem, Content: titleInstruction},
+			{Role: gogpt.ChatMessageRoleUser, Content: transcript.String()},
+		},
+		MaxTokens: 30,
+	}
+	applyModelSpecificLimitations(&request, aiChat.options.verbose)
+	response, err := aiChat.client.CreateChatCompletion(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", fmt.Errorf("no choices returned")
+	}
+	title := strings.Trim(strings.TrimSpace(response.Choices[0].Message.Content), "\"'「」“”")
+	if title == "" {
+		return "", fmt.Errorf("empty title returned")
+	}
+	return summarizeContent(title, 80), nil
+}
+
+// startTitle generates a title for the conversation in the background. The
+// chat loop picks it up with applyPendingTitle; nothing is printed meanwhile,
+// as the prompt may be on the terminal.
+func (aiChat *AIChat) startTitle() {
+	conv := aiChat.conversation
+	pending := &pendingTitle{conversationID: conv.ID, fallback: conv.Title, result: make(chan string, 1)}
+	messages := conv.ToGPTMessages()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+		defer cancel()
+		title, err := aiChat.generateTitle(ctx, messages)
+		pending.err = err
+		pending.result <- title
+	}()
+	aiChat.pendingTitle = pending
+}
+
+// applyPendingTitle sets the title generated in the background, if it is
+// ready or, when wait is set, once it is. The title is dropped when the
+// conversation was renamed or replaced in the meantime.
+func (aiChat *AIChat) applyPendingTitle(wait bool) {
+	pending := aiChat.pendingTitle
+	if pending == nil {
+		return
+	}
+	var title string
+	if wait {
+		title = <-pending.result
+	} else {
+		select {
+		case title = <-pending.result:
+		default:
+			return
+		}
+	}
+	aiChat.pendingTitle = nil
+	if pending.err != nil && aiChat.options.verbose {
+		log.Printf("Failed to generate a title: %v", pending.err)
+	}
+	conv := aiChat.conversation
+	if title != "" && conv != nil && conv.ID == pending.conversationID && conv.Title == pending.fallback {
+		conv.Title = title
+	}
+}
+
+// renameConversation sets the title, or generates one when title is empty.
+func (aiChat *AIChat) renameConversation(title string) error {
+	aiChat.pendingTitle = nil
+	if title == "" {
+		if len(aiChat.conversation.Path()) == 0 {
+			return fmt.Errorf("no messages to generate a title from")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), titleTimeout)
+		defer cancel()
+		generated, err := aiChat.generateTitle(ctx, aiChat.conversation.ToGPTMessages())
+		if err != nil {
+			return err
+		}
+		title = generated
+	}
+	aiChat.conversation.Title = title
+	aiChat.conversation.Untitled = false
+	fmt.Printf("Title: %s\n", title)
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func TestGetConversationTitleMultibyte(t *testing.T) {
+	content := strings.Repeat("日本語のタイトル", 5)
+	title := GetConversationTitle([]gogpt.ChatCompletionMessage{{Role: "user", Content: content}})
+	if !utf8.ValidString(title) {
+		t.Fatalf("Expected valid UTF-8, got %q", title)
+	}
+	if title != string([]rune(content)[:29])+"..." {
+		t.Errorf("Expected 29 characters and an ellipsis, got %q", title)
+	}
+	title = GetConversationTitle([]gogpt.ChatCompletionMessage{{Role: "user", Content: "Two\nlines"}})
+	if title != "Two lines" {
+		t.Errorf("Expected the title on one line, got %q", title)
+	}
+}
+
+func TestAutoTitle(t *testing.T) {
+	aiChat, requests := newTestAIChat(t, "Hi there!", "\"Friendly greeting\"\n")
+	aiChat.config.AutoTitle = true
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+
+	if err := aiChat.reply(0.5); err != nil {
+		t.Fatalf("reply() returned an error: %v", err)
+	}
+	if aiChat.conversation.Title != "Hello" {
+		t.Errorf("Expected the fallback title until the generated one is ready, got %q", aiChat.conversation.Title)
+	}
+	aiChat.applyPendingTitle(true)
+	if aiChat.conversation.Title != "Friendly greeting" {
+		t.Errorf("Expected the generated title, got %q", aiChat.conversation.Title)
+	}
+	if len(*requests) != 2 || (*requests)[1].Model != defaultTitleModel {
+		t.Fatalf("Expected a title request to %s, got %+v", defaultTitleModel, *requests)
+	}
+	if got := (*requests)[1].Messages[1].Content; !strings.Contains(got, "user: Hello") || !strings.Contains(got, "assistant: Hi there!") {
+		t.Errorf("Expected the exchange in the title request, got %q", got)
+	}
+}
+
+func TestAutoTitleAfterRename(t *testing.T) {
+	aiChat, _ := newTestAIChat(t, "Hi there!", "Generated")
+	aiChat.config.AutoTitle = true
+	aiChat.config.TitleModel = "gpt-3.5-turbo"
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := aiChat.reply(0.5); err != nil {
+		t.Fatalf("reply() returned an error: %v", err)
+	}
+	aiChat.conversation.Title = "Mine"
+	aiChat.applyPendingTitle(true)
+	if aiChat.conversation.Title != "Mine" {
+		t.Errorf("Expected a manual title to be kept, got %q", aiChat.conversation.Title)
+	}
+}
+
+func TestAutoTitleFirstReply(t *testing.T) {
+	// started from a prompt, with few-shot messages
+	aiChat, _ := newTestAIChat(t, "Bonjour", "French greeting")
+	aiChat.config.AutoTitle = true
+	aiChat.startPrompt("translate", &Prompt{Messages: []Message{
+		{Role: "system", Content: "Translate to French."},
+		{Role: "user", Content: "Hi"},
+		{Role: "assistant", Content: "Salut"},
+	}})
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := aiChat.reply(0.5); err != nil {
+		t.Fatalf("reply() returned an error: %v", err)
+	}
+	aiChat.applyPendingTitle(true)
+	if aiChat.conversation.Title != "French greeting" {
+		t.Errorf("Expected a seeded reply not to count as the first one, got %q", aiChat.conversation.Title)
+	}
+
+	aiChat, _ = newTestAIChat(t, "Bonjour", "French greeting")
+	aiChat.config.AutoTitle = true
+	aiChat.startPrompt("translate", &Prompt{Messages: []Message{{Role: "system", Content: "Translate to French."}}})
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := aiChat.reply(0.5); err != nil {
+		t.Fatalf("reply() returned an error: %v", err)
+	}
+	if aiChat.conversation.Title != "translate" {
+		t.Errorf("Expected the prompt name until the title is generated, got %q", aiChat.conversation.Title)
+	}
+	aiChat.applyPendingTitle(true)
+	if aiChat.conversation.Title != "French greeting" {
+		t.Errorf("Expected a generated title for a prompt conversation, got %q", aiChat.conversation.Title)
+	}
+
+	// shell output added before the first exchange
+	aiChat, _ = newTestAIChat(t, "It failed.", "Build failure")
+	aiChat.config.AutoTitle = true
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "make: *** [all] Error 1")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Why?")
+	if err := aiChat.reply(0.5); err != nil {
+		t.Fatalf("reply() returned an error: %v", err)
+	}
+	aiChat.applyPendingTitle(true)
+	if aiChat.conversation.Title != "Build failure" {
+		t.Errorf("Expected a generated title after shell output, got %q", aiChat.conversation.Title)
+	}
+
+	// named by the user before the first reply
+	aiChat, requests := newTestAIChat(t, "Hi there!")
+	aiChat.config.AutoTitle = true
+	if err := aiChat.renameConversation("Mine"); err != nil {
+		t.Fatal(err)
+	}
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := aiChat.reply(0.5); err != nil {
+		t.Fatalf("reply() returned an error: %v", err)
+	}
+	aiChat.applyPendingTitle(true)
+	if aiChat.conversation.Title != "Mine" || len(*requests) != 1 {
+		t.Errorf("Expected the user's title to be kept without a title request, got %q", aiChat.conversation.Title)
+	}
+}
+
+func TestAutoTitleFailure(t *testing.T) {
+	aiChat, _ := newTestAIChat(t)
+	aiChat.client = failingClient(t)
+	aiChat.options.verbose = true
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	aiChat.conversation.Title = "Hello"
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	aiChat.startTitle()
+	aiChat.applyPendingTitle(true)
+	if aiChat.conversation.Title != "Hello" {
+		t.Errorf("Expected the fallback title to be kept, got %q", aiChat.conversation.Title)
+	}
+	if !strings.Contains(logged.String(), "Failed to generate a title") {
+		t.Errorf("Expected the failure to be reported when the title is picked up, got %q", logged.String())
+	}
+}
+
+func TestRenameConversation(t *testing.T) {
+	aiChat, requests := newTestAIChat(t, "Generated title")
+	if err := aiChat.renameConversation(""); err == nil {
+		t.Error("Expected an error without messages")
+	}
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := aiChat.renameConversation("Custom"); err != nil || aiChat.conversation.Title != "Custom" {
+		t.Errorf("Expected the title to be set, got %q, %v", aiChat.conversation.Title, err)
+	}
+	if err := aiChat.renameConversation(""); err != nil || aiChat.conversation.Title != "Generated title" {
+		t.Errorf("Expected a generated title, got %q, %v", aiChat.conversation.Title, err)
+	}
+	if len(*requests) != 1 {
+		t.Errorf("Expected one title request, got %d", len(*requests))
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+
+	tokenizer "github.com/samber/go-gpt-3-encoder"
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+// ModelPrice is the price of a model in US dollars per million tokens.
+type ModelPrice struct {
+	Input  float64 `yaml:"input"`
+	Output float64 `yaml:"output"`
+}
+
+// priceOfModel returns the price of a model. Prices in the config file take
+// precedence over the built-in ones. It returns false when the price is unknown.
+func priceOfModel(model string, prices map[string]ModelPrice) (ModelPrice, bool) {
+	if price, ok := prices[model]; ok {
+		return price, true
+	}
+	switch model {
+	case gogpt.GPT4, gogpt.GPT40314, gogpt.GPT40613:
+		return ModelPrice{Input: 30, Output: 60}, true
+	case gogpt.GPT432K, gogpt.GPT432K0314, gogpt.GPT432K0613:
+		return ModelPrice{Input: 60, Output: 120}, true
+	case gogpt.GPT3Dot5Turbo, gogpt.GPT3Dot5Turbo0125:
+		return ModelPrice{Input: 0.5, Output: 1.5}, true
+	case gogpt.GPT3Dot5Turbo16K, gogpt.GPT3Dot5Turbo16K0613:
+		return ModelPrice{Input: 3, Output: 4}, true
+	case gogpt.GPT4o, gogpt.GPT4o20240806, gogpt.GPT4o20241120:
+		return ModelPrice{Input: 2.5, Output: 10}, true
+	case gogpt.GPT4oMini, gogpt.GPT4oMini20240718:
+		return ModelPrice{Input: 0.15, Output: 0.6}, true
+	case gogpt.O4Mini, gogpt.O4Mini20250416, gogpt.O3Mini, gogpt.O3Mini20250131:
+		return ModelPrice{Input: 1.1, Output: 4.4}, true
+	case gogpt.O3, gogpt.O320250416:
+		return ModelPrice{Input: 2, Output: 8}, true
+	default:
+		return ModelPrice{}, false
+	}
+}
+
+// sessionUsage adds up the tokens sent and received during a chat session.
+type sessionUsage struct {
+	promptTokens     int
+	completionTokens int
+	cost             float64
+	// unpriced is set when a request used a model with an unknown price.
+	unpriced bool
+}
+
+// add records a request to model.
+func (u *sessionUsage) add(model string, prices map[string]ModelPrice, promptTokens, completionTokens int) {
+	u.promptTokens += promptTokens
+	u.completionTokens += completionTokens
+	price, ok := priceOfModel(model, prices)
+	if !ok {
+		u.unpriced = true
+		return
+	}
+	u.cost += (float64(promptTokens)*price.Input + float64(completionTokens)*price.Output) / 1e6
+}
+
+func (u *sessionUsage) String() string {
+	s := fmt.Sprintf("%d prompt + %d completion tokens, $%.4f", u.promptTokens, u.completionTokens, u.cost)
+	if u.unpriced {
+		s += " (some models have no known price, set prices in config.yml)"
+	}
+	return s
+}
+
+// countTokens counts the tokens of text with the chat's encoder, which is
+// created on first use.
+func (aiChat *AIChat) countTokens(text string) (int, error) {
+	if aiChat.encoder == nil {
+		encoder, err := tokenizer.NewEncoder()
+		if err != nil {
+			return 0, err
+		}
+		aiChat.encoder = encoder
+	}
+	encoded, err := aiChat.encoder.Encode(text)
+	if err != nil {
+		return 0, err
+	}
+	return len(encoded), nil
+}
+
+// recordUsage adds a request to model and its response to the session
+// usage. It takes the token counts reported by the API, or counts them when
+// reported is nil, and returns them with whether they were counted locally.
+func (aiChat *AIChat) recordUsage(model string, messages []gogpt.ChatCompletionMessage, response string, reported *gogpt.Usage) (int, int, bool) {
+	if reported != nil {
+		aiChat.usage.add(model, aiChat.config.Prices, reported.PromptTokens, reported.CompletionTokens)
+		return reported.PromptTokens, reported.CompletionTokens, false
+	}
+	promptTokens := 0
+	for _, msg := range messages {
+		count, err := aiChat.countTokens(msg.Content)
+		if err != nil {
+			return 0, 0, true
+		}
+		promptTokens += count
+	}
+	completionTokens, err := aiChat.countTokens(response)
+	if err != nil {
+		return 0, 0, true
+	}
+	aiChat.usage.add(model, aiChat.config.Prices, promptTokens, completionTokens)
+	return promptTokens, completionTokens, true
+}
+
+// messageKey identifies a message across conversations, whose message IDs
+// repeat.
+type messageKey struct {
+	conversationID string
+	messageID      string
+}
+
+// contextTokens returns the token count of each message of the active branch
+// and of the files waiting to be sent with the next message.
+func (aiChat *AIChat) contextTokens() ([]int, int, error) {
+	if aiChat.messageTokens == nil {
+		aiChat.messageTokens = map[messageKey]int{}
+	}
+	path := aiChat.conversation.Path()
+	counts := make([]int, len(path))
+	for i, msg := range path {
+		key := messageKey{aiChat.conversation.ID, msg.ID}
+		count, ok := aiChat.messageTokens[key]
+		if !ok {
+			var err error
+			if count, err = aiChat.countTokens(msg.Content); err != nil {
+				return nil, 0, err
+			}
+			aiChat.messageTokens[key] = count
+		}
+		counts[i] = count
+	}
+	attached := 0
+	for _, a := range aiChat.attachments {
+		count, err := aiChat.countTokens(formatAttachment(a))
+		if err != nil {
+			return nil, 0, err
+		}
+		attached += count
+	}
+	return counts, attached, nil
+}
+
+// printTokens shows how much of the model's context the conversation uses.
+func (aiChat *AIChat) printTokens() error {
+	counts, attached, err := aiChat.contextTokens()
+	if err != nil {
+		return err
+	}
+	path := aiChat.conversation.Path()
+	total := attached
+	for i, msg := range path {
+		fmt.Printf("%3d %-9s %6d  %s\n", i+1, msg.Role+":", counts[i], summarizeContent(msg.Content, 50))
+		total += counts[i]
+	}
+	if attached > 0 {
+		fmt.Printf("    %-9s %6d\n", "attached:", attached)
+	}
+	limit := tokenLimitOfModel(aiChat.options.model)
+	fmt.Printf("Context: %d / %d tokens (%d%%) for %s\n", total, limit, total*100/limit, aiChat.options.model)
+	budget := max(limit-total, 0)
+	if maxTokens := aiChat.options.maxTokens; maxTokens > 0 && maxTokens < budget {
+		fmt.Printf("Reply budget: %d tokens (max-tokens %d)\n", maxTokens, maxTokens)
+	} else {
+		fmt.Printf("Reply budget: %d tokens\n", budget)
+	}
+	fmt.Printf("Session: %s\n", &aiChat.usage)
+	return nil
+}
+
+// userPrompt returns the prompt for the user's message, showing the context
+// usage when show_usage is set in the config.
+func (aiChat *AIChat) userPrompt() string {
+	if !aiChat.config.ShowUsage {
+		return "user: "
+	}
+	counts, attached, err := aiChat.contextTokens()
+	if err != nil {
+		return "user: "
+	}
+	total := attached
+	for _, count := range counts {
+		total += count
+	}
+	limit := tokenLimitOfModel(aiChat.options.model)
+	return fmt.Sprintf("user [%d/%d %d%%]: ", total, limit, total*100/limit)
+}
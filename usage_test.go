@@ -0,0 +1,91 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	gogpt "github.com/sashabaranov/go-openai"
+)
+
+func TestPriceOfModel(t *testing.T) {
+	if price, ok := priceOfModel(gogpt.GPT4, nil); !ok || price.Input != 30 || price.Output != 60 {
+		t.Errorf("Unexpected price for gpt-4: %+v, %v", price, ok)
+	}
+	if _, ok := priceOfModel("local-llama", nil); ok {
+		t.Errorf("Expected an unknown model to have no price")
+	}
+	prices := map[string]ModelPrice{"local-llama": {Input: 1, Output: 2}, gogpt.GPT4: {Input: 10, Output: 20}}
+	if price, ok := priceOfModel(gogpt.GPT4, prices); !ok || price.Input != 10 {
+		t.Errorf("Expected the config to override the built-in price, got %+v", price)
+	}
+}
+
+func TestSessionUsage(t *testing.T) {
+	var usage sessionUsage
+	usage.add(gogpt.GPT4, nil, 1000, 500)
+	usage.add(gogpt.GPT4, nil, 2000, 500)
+	if usage.promptTokens != 3000 || usage.completionTokens != 1000 {
+		t.Errorf("Unexpected token counts: %+v", usage)
+	}
+	if usage.cost < 0.1499 || usage.cost > 0.1501 {
+		t.Errorf("Expected a cost of $0.15, got %f", usage.cost)
+	}
+	if strings.Contains(usage.String(), "no known price") {
+		t.Errorf("Expected no warning for priced models, got %q", usage.String())
+	}
+	usage.add("local-llama", nil, 10, 10)
+	if !strings.Contains(usage.String(), "no known price") {
+		t.Errorf("Expected a warning for an unpriced model, got %q", usage.String())
+	}
+}
+
+func TestUsageTracking(t *testing.T) {
+	aiChat, _ := newTestAIChat(t, "Hi there!")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello")
+	if err := aiChat.reply(0.5); err != nil {
+		t.Fatalf("reply() returned an error: %v", err)
+	}
+	if aiChat.usage.promptTokens == 0 || aiChat.usage.completionTokens == 0 || aiChat.usage.cost == 0 {
+		t.Errorf("Expected the reply to be recorded, got %+v", aiChat.usage)
+	}
+
+	counts, attached, err := aiChat.contextTokens()
+	if err != nil {
+		t.Fatalf("contextTokens() returned an error: %v", err)
+	}
+	if len(counts) != 2 || counts[0] == 0 || attached != 0 {
+		t.Errorf("Unexpected context tokens: %v, %d", counts, attached)
+	}
+
+	if got := aiChat.userPrompt(); got != "user: " {
+		t.Errorf("Expected the plain prompt by default, got %q", got)
+	}
+	aiChat.config.ShowUsage = true
+	total := counts[0] + counts[1]
+	if got := aiChat.userPrompt(); !strings.HasPrefix(got, "user [") || !strings.Contains(got, "/8192 ") {
+		t.Errorf("Expected the usage in the prompt for %d tokens, got %q", total, got)
+	}
+}
+
+func TestContextTokensCache(t *testing.T) {
+	aiChat := &AIChat{conversation: NewConversation("Test", "gpt-4")}
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hello, world!")
+	counts, _, err := aiChat.contextTokens()
+	if err != nil || len(counts) != 1 || counts[0] != 4 {
+		t.Fatalf("Expected 4 tokens, got %v, %v", counts, err)
+	}
+
+	// the counts are cached per message, not recounted before every prompt
+	key := messageKey{aiChat.conversation.ID, aiChat.conversation.ActiveLeaf}
+	aiChat.messageTokens[key] = 100
+	if counts, _, _ := aiChat.contextTokens(); counts[0] != 100 {
+		t.Errorf("Expected the cached count, got %v", counts)
+	}
+
+	// another conversation reuses the message IDs
+	aiChat.conversation = NewConversation("Other", "gpt-4")
+	aiChat.conversation.AddMessage(gogpt.ChatMessageRoleUser, "Hi")
+	if counts, _, _ := aiChat.contextTokens(); counts[0] != 1 {
+		t.Errorf("Expected the other conversation's message to be counted, got %v", counts)
+	}
+}